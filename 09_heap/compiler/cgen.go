@@ -1,74 +1,193 @@
 package compiler
 
 import (
+    "bytes"
     "fmt"
-    "os"
+    "io"
+    "math"
 )
 
+// System V ABI中传递前6个整型参数的寄存器
+var argreglist = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+var argbreglist = []string{"%dil", "%sil", "%dl", "%cl", "%r8b", "%r9b"}
+var argwreglist = []string{"%di", "%si", "%dx", "%cx", "%r8w", "%r9w"}
+var argdreglist = []string{"%edi", "%esi", "%edx", "%ecx", "%r8d", "%r9d"}
+
+// System V ABI中传递前8个浮点数参数的寄存器
+var argxmmlist = []string{"%xmm0", "%xmm1", "%xmm2", "%xmm3", "%xmm4", "%xmm5", "%xmm6", "%xmm7"}
+
+// 整数和浮点数返回值的寄存器，其余返回值写入调用者预留的栈空间
+var resultreglist = []string{"%rax", "%rdx"}
+var resultxmmlist = []string{"%xmm0", "%xmm1"}
+
+// mov指令中表示操作数大小的后缀
+var movsuffix = map[int]string{1: "b", 2: "w", 4: "l", 8: "q"}
+
+// 按System V ABI确定类型列表types中第i个值的位置：整数和指针依次使用nint个整数寄存器，
+// 浮点数依次使用nfloat个%xmm寄存器。在寄存器中时reg为寄存器的序号，stack为-1；
+// 寄存器用完后的值按顺序位于栈上，reg为-1，stack为在栈上的序号
+func abiloc(types []Type, i int, nint int, nfloat int) (reg int, stack int) {
+    ints, floats, stacks := 0, 0, 0
+    for j := 0; j <= i; j++ {
+        reg, stack = -1, -1
+        switch {
+        case isFloat(types[j]) && floats < nfloat:
+            reg = floats
+            floats++
+        case !isFloat(types[j]) && ints < nint:
+            reg = ints
+            ints++
+        default:
+            stack = stacks
+            stacks++
+        }
+    }
+    return reg, stack
+}
+
+// 第i个形参的位置
+func argloc(types []Type, i int) (reg int, stack int) {
+    return abiloc(types, i, len(argreglist), len(argxmmlist))
+}
+
+// 第i个返回值的位置
+func resultloc(types []Type, i int) (reg int, stack int) {
+    return abiloc(types, i, len(resultreglist), len(resultxmmlist))
+}
+
+// 类型列表中位于栈上的值的个数
+func stackcount(types []Type, loc func([]Type, int) (int, int)) int {
+    n := 0
+    for i := range types {
+        if _, stack := loc(types, i); stack != -1 {
+            n++
+        }
+    }
+    return n
+}
+
 type Cgen struct {
     tree     *ASTNode   // 语法树
-    outfile  *os.File   // 汇编结果
+    outfile  io.Writer  // 汇编结果
     reglist  []string   // 寄存器列表(64位)
     breglist []string   // 寄存器列表(低8位)
+    wreglist []string   // 寄存器列表(低16位)
+    dreglist []string   // 寄存器列表(低32位)
     freereg  []bool     // 寄存器对应的状态
-    label    int        // 标签id
+    calleesaved []bool  // 寄存器是否由被调用者保存（System V ABI中的%r12-%r15）
+    usedreg  []bool     // 当前函数中用到过的寄存器
+    spilled  int        // 寄存器不足时已溢出到栈上的寄存器个数
+
+    // 当前函数栈帧中局部变量之下的临时槽，用于保存溢出的寄存器，按栈的方式使用
+    localsize int       // 局部变量占用的字节数
+    tmpdepth  int       // 正在使用的临时槽个数
+    tmpmax    int       // 函数中同时使用的临时槽个数的最大值
+    loops    []loopLabels    // 正在生成代码的循环，内层循环在后，用于break和continue
+    labels   map[string]int  // 当前函数中的语句标签对应的汇编标签
+    strlits  []strLiteral    // 字符串常量，在汇编尾生成
+
+    ctx      *Compilation
+    sym      *Symtable  // 符号表
+    pos      Pos        // 正在生成代码的节点位置，用于错误信息
+}
+
+// 字符串常量：字符串头的标签和字节数据的标签
+type strLiteral struct {
+    value string
+    hdr   int
+    data  int
 }
 
-func NewCgen(tree *ASTNode, outfile *os.File) *Cgen {
+// 循环的跳转目标
+type loopLabels struct {
+    name  string  // 循环的语句标签，无标签时为""
+    brk   int     // break跳转到循环之后
+    cont  int     // continue跳转到下一次迭代
+}
+
+func NewCgen(ctx *Compilation, tree *ASTNode, outfile io.Writer) *Cgen {
     return &Cgen{
+        ctx:     ctx,
+        sym:     ctx.Sym,
         tree:    tree,
         outfile: outfile,
         reglist: []string{"%r8", "%r9", "%r10", "%r11", "%r12", "%r13", "%r14", "%r15"},
         breglist: []string{"%r8b", "%r9b", "%r10b", "%r11b", "%r12b", "%r13b", "%r14b", "%r15b"},
+        wreglist: []string{"%r8w", "%r9w", "%r10w", "%r11w", "%r12w", "%r13w", "%r14w", "%r15w"},
+        dreglist: []string{"%r8d", "%r9d", "%r10d", "%r11d", "%r12d", "%r13d", "%r14d", "%r15d"},
         freereg: []bool{true, true, true, true, true, true, true, true},
-        label: 0,
+        calleesaved: []bool{false, false, false, false, true, true, true, true},
+        usedreg: make([]bool, 8),
     }
 }
 
-func (c *Cgen) GenAST() {
+// GenAST 生成整个语法树的汇编代码，出错时返回DiagnosticList
+func (c *Cgen) GenAST() (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(bailout); !ok {
+                panic(r)
+            }
+            err = c.ctx.Err()
+        }
+    }()
+
     c.cgpreamble()
     c.genAST(c.tree)
+    c.cgpostamble()
+    return nil
 }
 
 func (c *Cgen) genAST(tree *ASTNode) {
     if tree != nil {
         switch tree.nodeKind {
-        case PrintK, IfK, VarK, AssignK, ForK, FuncK, ReturnK:
+        case PrintK, IfK, VarK, AssignK, ForK, FuncK, ReturnK, BreakK, ContinueK, GotoK, LabelK, BlockK:
             c.genStmt(tree)
-        case OpK, ConstK, IdK, CallK, UnaryOpK:
+        case OpK, ConstK, IdK, CallK, UnaryOpK, ConvK, IndexK, SliceK, LenK:
             c.genExp(tree)
         default:
-            c.error("ERROR: not supported nodekind")
+            c.error("unsupported node kind")
         }
+        c.freeall_registers()  // 语句之间不保留寄存器
         c.genAST(tree.sibling)
     }
 }
 
 func (c *Cgen) genStmt(tree *ASTNode) {
+    c.pos = tree.pos
     switch tree.nodeKind {
     case PrintK:
         reg := c.genExp(tree.child[0])
-        c.cgprintint(reg)
-    case VarK:
-        //fmt.Println("111: ", tree.child[0].symbleid)
-        if !Gsym.symbles[tree.child[0].symbleid].IsLocal {
-            c.cgglobsym(tree.child[0].symbleid)
+        if isFloat(tree.child[0].vartype) {
+            c.cgprintfloat(reg, tree.child[0].vartype)
+        } else if isString(tree.child[0].vartype) {
+            c.cgprintstring(reg)
+        } else {
+            c.cgprintint(reg, tree.child[0].vartype)
         }
-    case AssignK:
-        reg := c.genExp(tree.child[0])
-        if Gsym.symbles[tree.symbleid].IsLocal {
-            if tree.token == MUL {
-                c.cgstorederef(reg, c.cgloadlocal(tree.symbleid), Gsym.symbles[tree.symbleid].Vartype)
-            } else {
-                c.cgstorelocal(reg, tree.symbleid)
+    case VarK:
+        if !c.sym.symbles[tree.child[0].symbleid].IsLocal {
+            init := tree.child[1]
+            for n := tree.child[0]; n != nil; n = n.sibling {
+                c.cgglobsym(n.symbleid, init)
+                if init != nil {
+                    init = init.sibling
+                }
             }
+        } else if tree.child[1] != nil {
+            c.genassign(tree.child[0], tree.child[1])
         } else {
-            if tree.token == MUL {
-                c.cgstorederef(reg, c.cgloadglob(tree.symbleid), Gsym.symbles[tree.symbleid].Vartype)
-            } else {
-                c.cgstoreglob(reg, tree.symbleid)
+            // 没有初始值的局部变量初始化为零值
+            for n := tree.child[0]; n != nil; n = n.sibling {
+                reg := c.cgloadzero(c.sym.symbles[n.symbleid].Vartype)
+                c.cgstorelocal(reg, n.symbleid)
+                c.free_register(reg)
             }
         }
+    case AssignK:
+        c.genassign(tree.child[0], tree.child[1])
+    case BlockK:
+        c.genAST(tree.child[0])
     case IfK:
         var Lfalse, Lend int
         Lfalse = c.genLabel()  // else分支的标签
@@ -76,7 +195,12 @@ func (c *Cgen) genStmt(tree *ASTNode) {
             Lend = c.genLabel()  // if语句尾的标签
         }
 
-        c.genIfExp(tree.child[0], Lfalse)  // 判断结果为false跳转到else标签
+        if tree.child[3] != nil {
+            c.genAST(tree.child[3])  // init语句
+            c.freeall_registers()
+        }
+
+        c.genIfExp(tree.child[0], Lfalse, false)  // 判断结果为false跳转到else标签
         c.freeall_registers()
         c.genAST(tree.child[1])  // if分支语句
         c.freeall_registers()
@@ -92,159 +216,411 @@ func (c *Cgen) genStmt(tree *ASTNode) {
         }
     case ForK:
         Lstart := c.genLabel()
+        Lpost := c.genLabel()  // continue跳转到post语句
         Lend := c.genLabel()
+        c.genAST(tree.child[2])  // init语句
         c.cglabel(Lstart)
-        c.genIfExp(tree.child[0], Lend)
-        c.freeall_registers()
+        if tree.child[0] != nil {
+            c.genIfExp(tree.child[0], Lend, false)
+            c.freeall_registers()
+        }
+        c.loops = append(c.loops, loopLabels{name: tree.litval, brk: Lend, cont: Lpost})
         c.genAST(tree.child[1])
+        c.loops = c.loops[:len(c.loops)-1]
         c.freeall_registers()
+        c.cglabel(Lpost)
+        c.genAST(tree.child[3])  // post语句
         c.cgjump(Lstart)
         c.cglabel(Lend)
+    case BreakK, ContinueK:
+        loop := c.findloop(tree.litval)
+        if tree.nodeKind == BreakK {
+            c.cgjump(loop.brk)
+        } else {
+            c.cgjump(loop.cont)
+        }
+    case LabelK:
+        c.cglabel(c.userlabel(tree.litval))
+        c.genAST(tree.child[0])
+    case GotoK:
+        c.cgjump(c.userlabel(tree.litval))
     case FuncK:
         Lend := c.genLabel()
-        c.cgfuncpreamble(tree.litval)
-        // 形参处理
-        if tree.child[0] != nil {
-           _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rdi, %d(%%rbp)\n", Gsym.symbles[tree.child[0].symbleid].Offset)
+        c.sym.SetEndLabel(tree.symbleid, Lend)  // 函数体中的return语句跳转到此标签
+
+        // 函数体先生成到缓冲区，确定临时槽的数量后才能计算栈帧大小
+        out := c.outfile
+        body := &bytes.Buffer{}
+        c.outfile = body
+        c.localsize = (c.sym.symbles[tree.symbleid].FuncOffset + 7) / 8 * 8
+        c.tmpdepth, c.tmpmax = 0, 0
+        c.labels = make(map[string]int)
+        for i := range c.usedreg {
+            c.usedreg[i] = false
+        }
+
+        // 形参处理：将寄存器传入的形参保存到栈帧
+        types := c.sym.ParamTypes(tree.symbleid)
+        i := 0
+        for param := tree.child[0]; param != nil; param = param.sibling {
+            if reg, _ := argloc(types, i); reg != -1 {
+                c.cgstoreparam(reg, param.symbleid)
+            }
+            i++
+        }
+        // 命名返回值初始化为零值
+        for result := tree.child[2]; result != nil; result = result.sibling {
+            reg := c.cgloadzero(c.sym.symbles[result.symbleid].Vartype)
+            c.cgstorelocal(reg, result.symbleid)
+            c.free_register(reg)
         }
         c.genAST(tree.child[1])
         c.freeall_registers()
         c.cglabel(Lend)
-        Gsym.SetEndLabel(tree.symbleid, Lend)
-        c.cgfuncpostamble(Gsym.GetFuncOffset(tree.symbleid))
+
+        // 用到的被调用者保存寄存器保存在临时槽之下
+        var saves []int
+        for i := range c.reglist {
+            if c.usedreg[i] && c.calleesaved[i] {
+                saves = append(saves, i)
+            }
+        }
+        c.outfile = out
+        framesize := (c.localsize + 8*c.tmpmax + 8*len(saves) + 15) / 16 * 16
+        c.cgfuncpreamble(c.symname(tree.symbleid), framesize)
+        c.cgcalleesave(saves, true)
+        _, _ = body.WriteTo(c.outfile)
+        c.cgcalleesave(saves, false)
+        c.cgfuncpostamble(framesize)
+        if tree.litval == "main" {
+            c.cgmainentry()
+        }
     case ReturnK:
-        reg := c.genExp(tree.child[0])
-        c.cgreturn(reg, tree.symbleid)
+        c.genreturn(tree)
     default:
-        c.error("Error: not supported statement")
+        c.error("unsupported statement")
     }
 }
 
 
 func (c *Cgen) genExp(tree *ASTNode) int {
     var leftreg, rightreg int
+    c.pos = tree.pos
 
-    if len(tree.child) == 1 {
+    if tree.nodeKind == UnaryOpK && tree.token == AMPER {
+        return c.cgaddress(tree.symbleid)  // 取地址不需要变量的值
+    } else if tree.nodeKind == CallK {
+        return c.gencall(tree)
+    } else if tree.nodeKind == OpK && (tree.token == AND || tree.token == OR) {
+        return c.genlogical(tree)  // 短路求值，右操作数不一定计算
+    } else if tree.nodeKind == SliceK {
+        return c.genslice(tree)
+    } else if len(tree.child) == 1 {
         leftreg = c.genExp(tree.child[0])  // 一个子节点
     } else if len(tree.child) == 2 {
-        //fmt.Println("111", tree.child[0], tree.child[1])
         leftreg = c.genExp(tree.child[0])
         rightreg = c.genExp(tree.child[1])
     }
 
     switch tree.nodeKind {
     case OpK:
+        if isFloat(tree.child[0].vartype) {
+            switch tree.token {
+            case ADD, SUB, MUL, QUO:
+                return c.cgfloatop(leftreg, rightreg, tree.token, tree.vartype)
+            case EQ, GT, LT, LE, GE, NE:
+                return c.cgfcompare_and_set(leftreg, rightreg, tree.token, tree.child[0].vartype)
+            }
+        }
+        if isString(tree.child[0].vartype) {
+            switch tree.token {
+            case ADD:
+                return c.cgcallruntime("mygo.strconcat", leftreg, rightreg)
+            case EQ, GT, LT, LE, GE, NE:
+                return c.cgscompare_and_set(leftreg, rightreg, tree.token)
+            }
+        }
         switch tree.token {
+        // 结果可能超出不足8字节的类型的范围，按结果的类型重新扩展
         case ADD:
-            return c.cgadd(leftreg, rightreg)
+            return c.cgextend(c.cgadd(leftreg, rightreg), tree.vartype)
         case SUB:
-            return c.cgsub(leftreg, rightreg)
+            return c.cgextend(c.cgsub(leftreg, rightreg), tree.vartype)
         case MUL:
-            return c.cgmul(leftreg, rightreg)
+            return c.cgextend(c.cgmul(leftreg, rightreg), tree.vartype)
         case QUO:
-            return c.cgdiv(leftreg, rightreg)
-        case EQ:
-            return c.cgcompare_and_set(leftreg, rightreg, EQ)
-        case GT:
-            return c.cgcompare_and_set(leftreg, rightreg, GT)
-        case LT:
-            return c.cgcompare_and_set(leftreg, rightreg, LT)
-        case LE:
-            return c.cgcompare_and_set(leftreg, rightreg, LE)
-        case GE:
-            return c.cgcompare_and_set(leftreg, rightreg, GE)
-        case NE:
-            return c.cgcompare_and_set(leftreg, rightreg, NE)
+            return c.cgextend(c.cgdiv(leftreg, rightreg, tree.vartype), tree.vartype)
+        case REM:
+            return c.cgmod(leftreg, rightreg, tree.vartype)
+        case AMPER:
+            return c.cgand(leftreg, rightreg)
+        case PIPE:
+            return c.cgor(leftreg, rightreg)
+        case XOR:
+            return c.cgxor(leftreg, rightreg)
+        case ANDNOT:
+            return c.cgandnot(leftreg, rightreg)
+        case SHL:
+            return c.cgextend(c.cgshl(leftreg, rightreg), tree.vartype)
+        case SHR:
+            return c.cgshr(leftreg, rightreg, tree.vartype)
+        case EQ, GT, LT, LE, GE, NE:
+            return c.cgcompare_and_set(leftreg, rightreg, tree.token, tree.child[0].vartype)
         default:
+            c.error(fmt.Sprintf("unsupported operator %s", tree.token))
             return -1
         }
     case ConstK:
+        if tree.token == STRLIT {
+            return c.cgloadstring(tree.litval)
+        }
+        if isFloat(tree.vartype) {
+            return c.cgloadfloat(tree.fval, tree.vartype)
+        }
         return c.cgloadint(tree.intval)
     case IdK:
-        if Gsym.symbles[tree.symbleid].IsLocal {
+        if c.sym.symbles[tree.symbleid].IsLocal {
             return c.cgloadlocal(tree.symbleid)
         } else {
             return c.cgloadglob(tree.symbleid)
         }
-    case CallK:
-        return c.cgcall(leftreg, tree.symbleid)
     case UnaryOpK:
         switch tree.token {
         case MUL:
-            return c.cgderef(leftreg, Gsym.symbles[tree.symbleid].Vartype)
+            return c.cgderef(leftreg, tree.vartype)
         case AMPER:
             return c.cgaddress(tree.symbleid)
+        case ADD:
+            return leftreg
+        case SUB:
+            if isFloat(tree.vartype) {
+                return c.cgfneg(leftreg, tree.vartype)
+            }
+            return c.cgextend(c.cgneg(leftreg), tree.vartype)
+        case NOT:
+            return c.cglognot(leftreg)
+        case XOR:
+            return c.cgextend(c.cginvert(leftreg), tree.vartype)
         default:
+            c.error(fmt.Sprintf("unsupported operator %s", tree.token))
             return -1
         }
+    case ConvK:
+        return c.cgwiden(leftreg, tree.child[0].vartype, tree.vartype)
+    case IndexK:
+        return c.cgindex(leftreg, rightreg)
+    case LenK:
+        return c.cgstrlen(leftreg)
     default:
         return -1
     }
 }
 
-func (c *Cgen) genIfExp(tree *ASTNode, label int) int {
-    var leftreg, rightreg int
+// 条件表达式：表达式的值为cond时跳转到label，否则继续执行。
+// &&和||短路求值，两条路径上分配的寄存器相同
+func (c *Cgen) genIfExp(tree *ASTNode, label int, cond bool) {
+    c.pos = tree.pos
+    switch {
+    case tree.nodeKind == OpK && tree.token == AND:
+        if cond {
+            Lskip := c.genLabel()  // 左操作数为false时整个表达式为false
+            c.genIfExp(tree.child[0], Lskip, false)
+            c.genIfExp(tree.child[1], label, true)
+            c.cglabel(Lskip)
+        } else {
+            c.genIfExp(tree.child[0], label, false)
+            c.genIfExp(tree.child[1], label, false)
+        }
+    case tree.nodeKind == OpK && tree.token == OR:
+        if cond {
+            c.genIfExp(tree.child[0], label, true)
+            c.genIfExp(tree.child[1], label, true)
+        } else {
+            Lskip := c.genLabel()  // 左操作数为true时整个表达式为true
+            c.genIfExp(tree.child[0], Lskip, true)
+            c.genIfExp(tree.child[1], label, false)
+            c.cglabel(Lskip)
+        }
+    case tree.nodeKind == UnaryOpK && tree.token == NOT:
+        c.genIfExp(tree.child[0], label, !cond)
+    case tree.nodeKind == OpK && jumpdict[tree.token] != "" && !isFloat(tree.child[0].vartype) && !isString(tree.child[0].vartype):
+        leftreg := c.genExp(tree.child[0])
+        rightreg := c.genExp(tree.child[1])
+        c.cgcompare_and_jump(leftreg, rightreg, tree.token, tree.child[0].vartype, label, cond)
+    default:
+        c.cgtest_and_jump(c.genExp(tree), label, cond)
+    }
+}
 
-    if len(tree.child) == 1 {
-        leftreg = c.genIfExp(tree.child[0], -1)  // 一个子节点
-    } else if len(tree.child) == 2 {
-        leftreg = c.genIfExp(tree.child[0], -1)  // 不支持多个比较运算符
-        rightreg = c.genIfExp(tree.child[1], -1)
+// &&和||作为值：结果为1或0
+func (c *Cgen) genlogical(tree *ASTNode) int {
+    Lfalse := c.genLabel()
+    Lend := c.genLabel()
+    r := c.alloc_register()  // 在分支之前分配，保证两条路径上寄存器的状态一致
+    c.genIfExp(tree, Lfalse, false)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t$1, %s\n", c.reglist[r])
+    c.cgjump(Lend)
+    c.cglabel(Lfalse)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t$0, %s\n", c.reglist[r])
+    c.cglabel(Lend)
+    return r
+}
+
+// 字符串的切片s[lo:hi]，省略的lo为0，省略的hi为字符串的长度
+func (c *Cgen) genslice(tree *ASTNode) int {
+    s := c.genExp(tree.child[0])
+    var lo, hi int
+    if tree.child[1] != nil {
+        lo = c.genExp(tree.child[1])
+    } else {
+        lo = c.cgloadint(0)
+    }
+    if tree.child[2] != nil {
+        hi = c.genExp(tree.child[2])
+    } else {
+        hi = c.alloc_register()
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t8(%s), %s\n", c.reglist[s], c.reglist[hi])
     }
+    c.pos = tree.pos
+    return c.cgcallruntime("mygo.strslice", s, lo, hi)
+}
 
-    switch tree.nodeKind {
-    case OpK:
-        switch tree.token {
-        case ADD:
-            return c.cgadd(leftreg, rightreg)
-        case SUB:
-            return c.cgsub(leftreg, rightreg)
-        case MUL:
-            return c.cgmul(leftreg, rightreg)
-        case QUO:
-            return c.cgdiv(leftreg, rightreg)
-        case EQ:
-            return c.cgcompare_and_jump(leftreg, rightreg, EQ, label)
-        case GT:
-            return c.cgcompare_and_jump(leftreg, rightreg, GT, label)
-        case LT:
-            return c.cgcompare_and_jump(leftreg, rightreg, LT, label)
-        case LE:
-            return c.cgcompare_and_jump(leftreg, rightreg, LE, label)
-        case GE:
-            return c.cgcompare_and_jump(leftreg, rightreg, GE, label)
-        case NE:
-            return c.cgcompare_and_jump(leftreg, rightreg, NE, label)
-        default:
-            return -1
+// 赋值语句：先计算右边全部的值，再从左到右依次赋给左边的变量
+func (c *Cgen) genassign(lhs, rhs *ASTNode) {
+    if lhs.sibling == nil && rhs.sibling == nil {
+        c.genstore(c.genExp(rhs), lhs)
+        return
+    }
+
+    var slots []int
+    if rhs.sibling == nil {
+        slots = c.gentuplecall(rhs)  // a, b = f()
+    } else {
+        for e := rhs; e != nil; e = e.sibling {
+            slots = append(slots, c.genslot(e))
         }
-    case ConstK:
-        return c.cgloadint(tree.intval)
-    case IdK:
-        if Gsym.symbles[tree.symbleid].IsLocal {
-            return c.cgloadlocal(tree.symbleid)
+    }
+    for i, n := 0, lhs; n != nil; i, n = i+1, n.sibling {
+        c.pos = n.pos
+        c.genstore(c.cgloadslot(slots[i]), n)
+    }
+    for range slots {
+        c.popslot()
+    }
+}
+
+// 将寄存器r的值赋给变量或指针指向的变量lhs，并释放r
+func (c *Cgen) genstore(r int, lhs *ASTNode) {
+    id := lhs.symbleid
+    if lhs.nodeKind == UnaryOpK {
+        var ptr int
+        if c.sym.symbles[id].IsLocal {
+            ptr = c.cgloadlocal(id)
         } else {
-            return c.cgloadglob(tree.symbleid)
+            ptr = c.cgloadglob(id)
         }
-    case CallK:
-        return c.cgcall(leftreg, tree.symbleid)
-    case UnaryOpK:
-        switch tree.token {
-        case MUL:
-            return c.cgderef(leftreg, Gsym.symbles[tree.symbleid].Vartype)
-        case AMPER:
-            return c.cgaddress(tree.symbleid)
-        default:
-            return -1
+        c.cgstorederef(r, ptr, lhs.vartype)
+        c.free_register(ptr)
+    } else if c.sym.symbles[id].IsLocal {
+        c.cgstorelocal(r, id)
+    } else {
+        c.cgstoreglob(r, id)
+    }
+    c.free_register(r)
+}
+
+// return语句：计算全部返回值后按调用约定传回
+func (c *Cgen) genreturn(tree *ASTNode) {
+    var regs, slots []int
+    if e := tree.child[0]; e != nil && e.sibling == nil && e.nodeKind == CallK && len(c.sym.symbles[e.symbleid].Results) > 1 {
+        slots = c.gentuplecall(e)  // return f()
+        for _, slot := range slots {
+            regs = append(regs, c.cgloadslot(slot))
         }
-    default:
-        return -1
+    } else {
+        for e := tree.child[0]; e != nil; e = e.sibling {
+            regs = append(regs, c.genExp(e))
+        }
+    }
+    c.pos = tree.pos
+    c.cgreturn(regs, tree.symbleid)
+    for range slots {
+        c.popslot()
+    }
+}
+
+// 函数调用表达式，返回保存第一个返回值的寄存器
+func (c *Cgen) gencall(tree *ASTNode) int {
+    nargs := c.genargs(tree.child[0])
+    c.pos = tree.pos
+    c.cgcall(nargs, tree.symbleid, nil)
+    r := c.alloc_register()
+    if results := c.sym.symbles[tree.symbleid].Results; len(results) > 0 && isFloat(results[0]) {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%xmm0, %s\n", c.reglist[r])
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %s\n", c.reglist[r])
     }
+    return r
+}
+
+// 调用多返回值的函数，返回值依次保存到新的临时槽中，返回这些临时槽的偏移量
+func (c *Cgen) gentuplecall(tree *ASTNode) []int {
+    slots := make([]int, len(c.sym.symbles[tree.symbleid].Results))
+    for i := range slots {
+        slots[i] = c.pushslot()
+    }
+    nargs := c.genargs(tree.child[0])
+    c.pos = tree.pos
+    c.cgcall(nargs, tree.symbleid, slots)
+    return slots
+}
+
+// 从左到右计算实参并依次保存到新的临时槽中，返回实参个数
+func (c *Cgen) genargs(args *ASTNode) int {
+    if args != nil && args.sibling == nil && args.nodeKind == CallK && len(c.sym.symbles[args.symbleid].Results) > 1 {
+        return len(c.gentuplecall(args))  // f(g())：g的返回值依次保存在新的临时槽中，即为f的实参
+    }
+    n := 0
+    for arg := args; arg != nil; arg = arg.sibling {
+        c.genslot(arg)
+        n++
+    }
+    return n
+}
+
+// 计算表达式的值并保存到新的临时槽中，返回临时槽的偏移量。
+// 临时槽在计算前占用，计算过程中溢出寄存器所用的临时槽都位于其上
+func (c *Cgen) genslot(tree *ASTNode) int {
+    slot := c.pushslot()
+    r := c.genExp(tree)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[r], slot)
+    c.free_register(r)
+    return slot
+}
+
+// 查找break或continue的目标循环，name为空时是最内层的循环
+func (c *Cgen) findloop(name string) loopLabels {
+    for i := len(c.loops) - 1; i >= 0; i-- {
+        if name == "" || c.loops[i].name == name {
+            return c.loops[i]
+        }
+    }
+    c.error("break or continue outside loop")
+    return loopLabels{}
+}
+
+// 语句标签name对应的汇编标签，goto可以在标签定义之前引用
+func (c *Cgen) userlabel(name string) int {
+    l, ok := c.labels[name]
+    if !ok {
+        l = c.genLabel()
+        c.labels[name] = l
+    }
+    return l
 }
 
 func (c *Cgen) genLabel() int {
-    c.label++
-    return c.label-1
+    return c.ctx.newLabel()
 }
 
 
@@ -253,7 +629,8 @@ func (c *Cgen) genLabel() int {
 /**********************************************/
 
 func (c *Cgen) error(msg string) {
-    panic(msg)
+    c.ctx.addDiagnostic(&Diagnostic{Severity: SevError, Pos: c.pos, Msg: msg})
+    panic(bailout{})
 }
 
 // 设置所有寄存器为可用状态
@@ -261,62 +638,404 @@ func (c *Cgen) freeall_registers() {
     for i, _ := range c.freereg {
         c.freereg[i] = true
     }
-    //fmt.Println("After Freeall: ", c.freereg)
+    c.tmpdepth -= c.spilled
+    c.spilled = 0
 }
 
-// 分配一个空闲的寄存器
+// 分配一个空闲的寄存器。
+// 寄存器按栈的顺序分配和释放，没有空闲寄存器时轮流将已用的寄存器溢出到栈上的临时槽
 func (c *Cgen) alloc_register() int {
     for i := 0; i < len(c.reglist); i++ {
         if c.freereg[i] {
             c.freereg[i] = false
+            c.usedreg[i] = true
             return i
         }
     }
-    c.error("Error: Out of registers!")
-    return 0
+    r := c.spilled % len(c.reglist)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[r], c.pushslot())
+    c.spilled++
+    return r
 }
 
-// 释放一个使用状态的寄存器
+// 释放一个使用状态的寄存器，寄存器曾被溢出时从临时槽中恢复原来的值
 func (c *Cgen) free_register(reg int) {
+    if c.spilled > 0 {
+        c.spilled--
+        r := c.spilled % len(c.reglist)
+        if r != reg {
+            c.error(fmt.Sprintf("trying to free register %d out of order", reg))
+        }
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", c.topslot(), c.reglist[r])
+        c.popslot()
+        return
+    }
     if c.freereg[reg] != false {
-        c.error(fmt.Sprintf("Error: trying to free register %d\n", reg))
+        c.error(fmt.Sprintf("trying to free register %d", reg))
     }
     c.freereg[reg] = true
 }
 
+// 占用一个新的临时槽，返回其相对%rbp的偏移量
+func (c *Cgen) pushslot() int {
+    c.tmpdepth++
+    if c.tmpdepth > c.tmpmax {
+        c.tmpmax = c.tmpdepth
+    }
+    return c.topslot()
+}
+
+// 将临时槽中的值加载到新的寄存器
+func (c *Cgen) cgloadslot(slot int) int {
+    r := c.alloc_register()
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", slot, c.reglist[r])
+    return r
+}
+
+// 最近占用的临时槽相对%rbp的偏移量
+func (c *Cgen) topslot() int {
+    return -(c.localsize + 8*c.tmpdepth)
+}
+
+// 释放最近占用的临时槽
+func (c *Cgen) popslot() {
+    c.tmpdepth--
+}
+
 // 汇编头
 func (c *Cgen) cgpreamble() {
     c.freeall_registers()
-    _, _ = c.outfile.WriteString(`    .text
+    _, _ = io.WriteString(c.outfile, `    .text
 .LC0:
-    .string "%d\n"
-printint:
+    .string "%ld\n"
+.LC1:
+    .string "%lu\n"
+.LC2:
+    .string "%.*e"
+.LC3:
+    .string "panic: runtime error: index out of range [%ld] with length %ld\n"
+.LC4:
+    .string "panic: runtime error: slice bounds out of range [:%ld] with length %ld\n"
+.LC5:
+    .string "panic: runtime error: slice bounds out of range [%ld:%ld]\n"
+.LC6:
+    .string "+Inf"
+.LC7:
+    .string "-Inf"
+.LC8:
+    .string "NaN"
+.LC9:
+    .string "%.*g\n"
+mygo.printint:
 	pushq   %rbp
 	movq    %rsp, %rbp
 	subq    $16, %rsp
-	movl    %edi, -4(%rbp)
-	movl    -4(%rbp), %eax
-	movl    %eax, %esi
+	movq    %rdi, -8(%rbp)
+	movq    -8(%rbp), %rax
+	movq    %rax, %rsi
 	leaq	.LC0(%rip), %rdi
 	movl	$0, %eax
 	call	printf@PLT
 	nop
 	leave
 	ret
+mygo.printuint:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	movq    %rdi, %rsi
+	leaq	.LC1(%rip), %rdi
+	movl	$0, %eax
+	call	printf@PLT
+	leave
+	ret
+# 与Go的fmt.Println相同，用能还原%xmm0的最少位数打印，十进制指数小于-4或不小于6时
+# 使用指数形式。%edi不为0时%xmm0由float32转换而来，按float32还原
+mygo.printfloat:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	subq	$64, %rsp
+	movsd	%xmm0, -8(%rbp)
+	movl	%edi, -12(%rbp)
+	movq	%xmm0, %rax
+	shlq	$1, %rax
+	movabsq	$0xffe0000000000000, %rcx
+	cmpq	%rcx, %rax
+	jb	1f
+	leaq	.LC8(%rip), %rdi
+	ja	5f
+	leaq	.LC6(%rip), %rdi
+	cmpq	$0, -8(%rbp)
+	jge	5f
+	leaq	.LC7(%rip), %rdi
+	jmp	5f
+1:
+	movl	$0, -16(%rbp)
+2:
+	leaq	-48(%rbp), %rdi
+	movl	$32, %esi
+	leaq	.LC2(%rip), %rdx
+	movl	-16(%rbp), %ecx
+	movsd	-8(%rbp), %xmm0
+	movl	$1, %eax
+	call	snprintf@PLT
+	leaq	-48(%rbp), %rdi
+	movl	$0, %esi
+	cmpl	$0, -12(%rbp)
+	jne	3f
+	call	strtod@PLT
+	jmp	4f
+3:
+	call	strtof@PLT
+	cvtss2sd	%xmm0, %xmm0
+4:
+	ucomisd	-8(%rbp), %xmm0
+	jp	6f
+	je	7f
+6:
+	addl	$1, -16(%rbp)
+	cmpl	$17, -16(%rbp)
+	jl	2b
+7:
+	leaq	-48(%rbp), %rdi
+	movl	$101, %esi
+	call	strchr@PLT
+	leaq	1(%rax), %rdi
+	call	atoi@PLT
+	cmpl	$-4, %eax
+	jl	8f
+	cmpl	$6, %eax
+	jge	8f
+	movl	-16(%rbp), %esi
+	addl	$1, %esi
+	movl	$6, %ecx
+	cmpl	%ecx, %esi
+	cmovl	%ecx, %esi
+	leaq	.LC9(%rip), %rdi
+	movsd	-8(%rbp), %xmm0
+	movl	$1, %eax
+	call	printf@PLT
+	leave
+	ret
+8:
+	leaq	-48(%rbp), %rdi
+5:
+	call	puts@PLT
+	leave
+	ret
+# 字符串由%rdi指向的字符串头{数据地址, 长度}表示
+mygo.printstring:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	movq	stdout@GOTPCREL(%rip), %rcx
+	movq	(%rcx), %rcx
+	movq	8(%rdi), %rdx
+	movq	(%rdi), %rdi
+	movl	$1, %esi
+	call	fwrite@PLT
+	movl	$10, %edi
+	call	putchar@PLT
+	leave
+	ret
+# 拼接%rdi和%rsi，新字符串的数据紧随字符串头之后
+mygo.strconcat:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	subq	$32, %rsp
+	movq	%rdi, -8(%rbp)
+	movq	%rsi, -16(%rbp)
+	movq	8(%rdi), %rdi
+	addq	8(%rsi), %rdi
+	movq	%rdi, -24(%rbp)
+	addq	$16, %rdi
+	call	malloc@PLT
+	movq	%rax, -32(%rbp)
+	leaq	16(%rax), %rdi
+	movq	%rdi, (%rax)
+	movq	-24(%rbp), %rcx
+	movq	%rcx, 8(%rax)
+	movq	-8(%rbp), %rcx
+	movq	(%rcx), %rsi
+	movq	8(%rcx), %rdx
+	call	memcpy@PLT
+	movq	-32(%rbp), %rax
+	movq	(%rax), %rdi
+	movq	-8(%rbp), %rcx
+	addq	8(%rcx), %rdi
+	movq	-16(%rbp), %rcx
+	movq	(%rcx), %rsi
+	movq	8(%rcx), %rdx
+	call	memcpy@PLT
+	movq	-32(%rbp), %rax
+	leave
+	ret
+# 切片%rdi[%rsi:%rdx]，与原字符串共用数据
+mygo.strslice:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	subq	$32, %rsp
+	cmpq	8(%rdi), %rdx
+	ja	1f
+	cmpq	%rdx, %rsi
+	ja	1f
+	movq	%rdi, -8(%rbp)
+	movq	%rsi, -16(%rbp)
+	movq	%rdx, -24(%rbp)
+	movl	$16, %edi
+	call	malloc@PLT
+	movq	-8(%rbp), %rcx
+	movq	(%rcx), %rcx
+	addq	-16(%rbp), %rcx
+	movq	%rcx, (%rax)
+	movq	-24(%rbp), %rcx
+	subq	-16(%rbp), %rcx
+	movq	%rcx, 8(%rax)
+	leave
+	ret
+1:
+	movq	8(%rdi), %rcx
+	movq	%rsi, %rdi
+	movq	%rdx, %rsi
+	movq	%rcx, %rdx
+	call	mygo.panicslice
+# 比较%rdi和%rsi，结果小于、等于、大于0
+mygo.strcompare:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	subq	$16, %rsp
+	movq	8(%rdi), %rax
+	subq	8(%rsi), %rax
+	movq	%rax, -8(%rbp)
+	movq	8(%rdi), %rdx
+	cmpq	8(%rsi), %rdx
+	cmovaq	8(%rsi), %rdx
+	movq	(%rdi), %rdi
+	movq	(%rsi), %rsi
+	call	memcmp@PLT
+	movslq	%eax, %rax
+	testq	%rax, %rax
+	jne	1f
+	movq	-8(%rbp), %rax
+1:
+	leave
+	ret
+# 下标%rdi超出长度%rsi
+mygo.panicindex:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	movq	%rsi, %rcx
+	movq	%rdi, %rdx
+	leaq	.LC3(%rip), %rsi
+	jmp	mygo.panicmsg
+# 切片的下标%rdi:%rsi超出长度%rdx
+mygo.panicslice:
+	pushq   %rbp
+	movq    %rsp, %rbp
+	cmpq	%rdx, %rsi
+	ja	1f
+	movq	%rsi, %rcx
+	movq	%rdi, %rdx
+	leaq	.LC5(%rip), %rsi
+	jmp	mygo.panicmsg
+1:
+	movq	%rdx, %rcx
+	movq	%rsi, %rdx
+	leaq	.LC4(%rip), %rsi
+# 输出格式为%rsi、参数为%rdx和%rcx的错误信息，以退出码2结束程序
+mygo.panicmsg:
+	movq	stderr@GOTPCREL(%rip), %rdi
+	movq	(%rdi), %rdi
+	movl	$0, %eax
+	call	fprintf@PLT
+	movl	$2, %edi
+	call	exit@PLT
 
 `)
 }
 
+// 汇编尾：字符串常量，以及声明栈不可执行，避免链接器警告。
+// 字节数据位于只读段，字符串头{数据地址, 长度}需要重定位，位于.data.rel.ro段
+func (c *Cgen) cgpostamble() {
+    if len(c.strlits) > 0 {
+        _, _ = fmt.Fprintf(c.outfile, "\n\t.section\t.rodata\n")
+        for _, lit := range c.strlits {
+            _, _ = fmt.Fprintf(c.outfile, ".L%d:", lit.data)
+            for i := 0; i < len(lit.value); i++ {
+                if i%16 == 0 {
+                    if i > 0 {
+                        _, _ = fmt.Fprintf(c.outfile, "\n")
+                    }
+                    _, _ = fmt.Fprintf(c.outfile, "\t.byte\t%d", lit.value[i])
+                } else {
+                    _, _ = fmt.Fprintf(c.outfile, ",%d", lit.value[i])
+                }
+            }
+            _, _ = fmt.Fprintf(c.outfile, "\n")
+        }
+        _, _ = fmt.Fprintf(c.outfile, "\t.section\t.data.rel.ro,\"aw\"\n")
+        _, _ = fmt.Fprintf(c.outfile, "\t.align\t8\n")
+        for _, lit := range c.strlits {
+            _, _ = fmt.Fprintf(c.outfile, ".L%d:\t.quad\t.L%d\n", lit.hdr, lit.data)
+            _, _ = fmt.Fprintf(c.outfile, "\t.quad\t%d\n", len(lit.value))
+        }
+    }
+    _, _ = io.WriteString(c.outfile, "\t.section\t.note.GNU-stack,\"\",@progbits\n")
+}
+
 // 函数头
-func (c *Cgen) cgfuncpreamble(name string) {
-    //fmt.Println(Gsym.symbles[Gsym.Findglob(name)].FuncOffset)
+func (c *Cgen) cgfuncpreamble(name string, framesize int) {
     _, _ = fmt.Fprintf(c.outfile, "\n\t.text\n" +
         "\t.globl\t%s\n" +
         "\t.type\t%s, @function\n" +
         "%s:\n" +
         "\tpushq\t%%rbp\n" +
         "\tmovq\t%%rsp, %%rbp\n" +
-        "\taddq\t$%d,%%rsp\n", name, name, name, -Gsym.GetFuncOffset(Gsym.Findglob(name)))
+        "\taddq\t$%d,%%rsp\n", name, name, name, -framesize)
+}
+
+// 在函数头保存（save为true）或在函数尾恢复被调用者保存的寄存器
+func (c *Cgen) cgcalleesave(regs []int, save bool) {
+    for k, r := range regs {
+        offset := -(c.localsize + 8*c.tmpmax + 8*(k+1))
+        if save {
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[r], offset)
+        } else {
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", offset, c.reglist[r])
+        }
+    }
+}
+
+// 函数调用前将仍在使用的调用者保存寄存器存入临时槽，返回被保存的寄存器
+func (c *Cgen) cgsavelive() []int {
+    var saved []int
+    for i := range c.reglist {
+        if !c.freereg[i] && !c.calleesaved[i] {
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[i], c.pushslot())
+            saved = append(saved, i)
+        }
+    }
+    return saved
+}
+
+// 函数调用后恢复cgsavelive保存的寄存器
+func (c *Cgen) cgrestorelive(saved []int) {
+    for i := len(saved) - 1; i >= 0; i-- {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", c.topslot(), c.reglist[saved[i]])
+        c.popslot()
+    }
+}
+
+// C程序的入口main调用main.main，返回0作为进程的退出码
+func (c *Cgen) cgmainentry() {
+    _, _ = fmt.Fprintf(c.outfile, "\n\t.text\n" +
+        "\t.globl\tmain\n" +
+        "\t.type\tmain, @function\n" +
+        "main:\n" +
+        "\tpushq\t%%rbp\n" +
+        "\tmovq\t%%rsp, %%rbp\n" +
+        "\tcall\tmain.main\n" +
+        "\tmovq\t$0, %%rax\n" +
+        "\tpopq\t%%rbp\n" +
+        "\tret\n")
 }
 
 // 函数尾
@@ -329,17 +1048,24 @@ func (c *Cgen) cgfuncpostamble(offset int) {
 
 // 加载整型
 func (c *Cgen) cgloadint(value int) int {
-    //fmt.Println("value: ", value)
     r := c.alloc_register()
     _, _ = fmt.Fprintf(c.outfile, "\tmovq\t$%d, %s\n", value, c.reglist[r])
     return r
 }
 
+// 加载浮点数：寄存器中保存它的二进制表示
+func (c *Cgen) cgloadfloat(value float64, vartype Type) int {
+    if vartype == VAR_FLOAT32 {
+        return c.cgloadint(int(math.Float32bits(float32(value))))
+    }
+    return c.cgloadint(int(math.Float64bits(value)))
+}
+
 // 加法
 func (c *Cgen) cgadd(r1, r2 int) int {
-    _, _ = fmt.Fprintf(c.outfile, "\taddq\t%s, %s\n", c.reglist[r1], c.reglist[r2])
-    c.free_register(r1)
-    return r2
+    _, _ = fmt.Fprintf(c.outfile, "\taddq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
 }
 
 // 减法
@@ -351,76 +1077,526 @@ func (c *Cgen) cgsub(r1, r2 int) int {
 
 // 乘法
 func (c *Cgen) cgmul(r1, r2 int) int {
-    _, _ = fmt.Fprintf(c.outfile, "\timulq\t%s, %s\n", c.reglist[r1], c.reglist[r2])
-    c.free_register(r1)
-    return r2
+    _, _ = fmt.Fprintf(c.outfile, "\timulq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
 }
 
 // 除法
-func (c *Cgen) cgdiv(r1, r2 int) int {
+func (c *Cgen) cgdiv(r1, r2 int, vartype Type) int {
+    c.cgdivide(r1, r2, vartype)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax,%s\n", c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 取余，结果的符号与被除数相同
+func (c *Cgen) cgmod(r1, r2 int, vartype Type) int {
+    c.cgdivide(r1, r2, vartype)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rdx,%s\n", c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// r1除以r2，商在%rax中，余数在%rdx中。
+// 有符号数将被除数符号扩展到%rdx:%rax后用idivq，无符号数将%rdx清零后用divq。
+// 有符号数除以-1时不用idivq（最小的负数除以-1会产生异常），商为被除数取负，余数为0
+func (c *Cgen) cgdivide(r1, r2 int, vartype Type) {
     _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s,%%rax\n", c.reglist[r1])
+    if isUnsigned(vartype) {
+        _, _ = fmt.Fprintf(c.outfile, "\txorl\t%%edx,%%edx\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tdivq\t%s\n", c.reglist[r2])
+        return
+    }
+    Lneg, Lend := c.genLabel(), c.genLabel()
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$-1, %s\n", c.reglist[r2])
+    _, _ = fmt.Fprintf(c.outfile, "\tje\t.L%d\n", Lneg)
     _, _ = fmt.Fprintf(c.outfile, "\tcqo\n")
     _, _ = fmt.Fprintf(c.outfile, "\tidivq\t%s\n", c.reglist[r2])
-    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax,%s\n", c.reglist[r1])
+    c.cgjump(Lend)
+    c.cglabel(Lneg)
+    _, _ = fmt.Fprintf(c.outfile, "\tnegq\t%%rax\n")
+    _, _ = fmt.Fprintf(c.outfile, "\txorl\t%%edx,%%edx\n")
+    c.cglabel(Lend)
+}
+
+// 按位与
+func (c *Cgen) cgand(r1, r2 int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tandq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 按位或
+func (c *Cgen) cgor(r1, r2 int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\torq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 按位异或
+func (c *Cgen) cgxor(r1, r2 int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\txorq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 按位清除：r1 &^ r2
+func (c *Cgen) cgandnot(r1, r2 int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tnotq\t%s\n", c.reglist[r2])
+    _, _ = fmt.Fprintf(c.outfile, "\tandq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 左移。移位数通过%cl传递，移位数不小于64时结果为0
+func (c *Cgen) cgshl(r1, r2 int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rcx\n", c.reglist[r2])
+    _, _ = fmt.Fprintf(c.outfile, "\tshlq\t%%cl, %s\n", c.reglist[r1])
+    _, _ = fmt.Fprintf(c.outfile, "\txorl\t%%eax, %%eax\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$64, %%rcx\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tcmovaeq\t%%rax, %s\n", c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 右移。无符号数逻辑右移，移位数不小于64时结果为0；
+// 有符号数算术右移，移位数不小于64时按63位移位，结果为0或-1
+func (c *Cgen) cgshr(r1, r2 int, vartype Type) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rcx\n", c.reglist[r2])
+    if isUnsigned(vartype) {
+        _, _ = fmt.Fprintf(c.outfile, "\tshrq\t%%cl, %s\n", c.reglist[r1])
+        _, _ = fmt.Fprintf(c.outfile, "\txorl\t%%eax, %%eax\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$64, %%rcx\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tcmovaeq\t%%rax, %s\n", c.reglist[r1])
+        c.free_register(r2)
+        return r1
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t$63, %%rax\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$64, %%rcx\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tcmovaeq\t%%rax, %%rcx\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tsarq\t%%cl, %s\n", c.reglist[r1])
+    c.free_register(r2)
+    return r1
+}
+
+// 取负
+func (c *Cgen) cgneg(r int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tnegq\t%s\n", c.reglist[r])
+    return r
+}
+
+// 按位取反
+func (c *Cgen) cginvert(r int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tnotq\t%s\n", c.reglist[r])
+    return r
+}
+
+// 逻辑非：值为0时结果为1，否则为0
+func (c *Cgen) cglognot(r int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$0, %s\n", c.reglist[r])
+    _, _ = fmt.Fprintf(c.outfile, "\tsete\t%s\n", c.breglist[r])
+    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s, %s\n", c.breglist[r], c.reglist[r])
+    return r
+}
+
+// 浮点数指令的后缀：ss为单精度，sd为双精度
+func fsuffix(vartype Type) string {
+    if vartype == VAR_FLOAT32 {
+        return "ss"
+    }
+    return "sd"
+}
+
+// 将寄存器r中的浮点数移入%xmm寄存器xmm
+func (c *Cgen) cgtoxmm(r int, xmm string) {
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s\n", c.reglist[r], xmm)
+}
+
+// 将%xmm0中类型为vartype的浮点数移入寄存器r，float32的高32位清零
+func (c *Cgen) cgfromxmm(r int, vartype Type) {
+    if vartype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovd\t%%xmm0, %s\n", c.dreglist[r])
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%xmm0, %s\n", c.reglist[r])
+    }
+}
+
+// 浮点数的加减乘除
+var fopdict = map[Token]string{
+    ADD: "add",
+    SUB: "sub",
+    MUL: "mul",
+    QUO: "div",
+}
+
+func (c *Cgen) cgfloatop(r1, r2 int, how Token, vartype Type) int {
+    c.cgtoxmm(r1, "%xmm0")
+    c.cgtoxmm(r2, "%xmm1")
+    _, _ = fmt.Fprintf(c.outfile, "\t%s%s\t%%xmm1, %%xmm0\n", fopdict[how], fsuffix(vartype))
+    c.cgfromxmm(r1, vartype)
+    c.free_register(r2)
+    return r1
+}
+
+// 浮点数取负：翻转符号位
+func (c *Cgen) cgfneg(r int, vartype Type) int {
+    if vartype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tbtcl\t$31, %s\n", c.dreglist[r])
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tbtcq\t$63, %s\n", c.reglist[r])
+    }
+    return r
+}
+
+// 比较浮点数r1和r2，结果为1或0。
+// ucomis比较无序（有NaN）时ZF、PF、CF都为1，<和<=交换操作数后用seta、setae，
+// ==和!=还要检查PF，使NaN与任何值都不相等
+func (c *Cgen) cgfcompare_and_set(r1 int, r2 int, how Token, vartype Type) int {
+    c.cgtoxmm(r1, "%xmm0")
+    c.cgtoxmm(r2, "%xmm1")
+    c.free_register(r2)
+    if how == LT || how == LE {
+        _, _ = fmt.Fprintf(c.outfile, "\tucomi%s\t%%xmm0, %%xmm1\n", fsuffix(vartype))
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tucomi%s\t%%xmm1, %%xmm0\n", fsuffix(vartype))
+    }
+    switch how {
+    case GT, LT:
+        _, _ = fmt.Fprintf(c.outfile, "\tseta\t%s\n", c.breglist[r1])
+    case GE, LE:
+        _, _ = fmt.Fprintf(c.outfile, "\tsetae\t%s\n", c.breglist[r1])
+    case EQ:
+        _, _ = fmt.Fprintf(c.outfile, "\tsete\t%s\n", c.breglist[r1])
+        _, _ = fmt.Fprintf(c.outfile, "\tsetnp\t%%al\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tandb\t%%al, %s\n", c.breglist[r1])
+    case NE:
+        _, _ = fmt.Fprintf(c.outfile, "\tsetne\t%s\n", c.breglist[r1])
+        _, _ = fmt.Fprintf(c.outfile, "\tsetp\t%%al\n")
+        _, _ = fmt.Fprintf(c.outfile, "\torb\t%%al, %s\n", c.breglist[r1])
+    default:
+        c.error("unsupported compare token")
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s, %s\n", c.breglist[r1], c.reglist[r1])
+    return r1
+}
+
+// 整数转换为浮点数。cvtsi2sd按有符号数转换，最高位为1的uint64先右移一位
+// （保留最低位以正确舍入），转换后再乘2
+func (c *Cgen) cgitof(r int, oldtype Type, newtype Type) int {
+    suffix := fsuffix(newtype)
+    if isUnsigned(oldtype) && typeSize(oldtype) == 8 {
+        Lbig := c.genLabel()
+        Lend := c.genLabel()
+        _, _ = fmt.Fprintf(c.outfile, "\ttestq\t%s, %s\n", c.reglist[r], c.reglist[r])
+        _, _ = fmt.Fprintf(c.outfile, "\tjs\t.L%d\n", Lbig)
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtsi2%sq\t%s, %%xmm0\n", suffix, c.reglist[r])
+        c.cgjump(Lend)
+        c.cglabel(Lbig)
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rax\n", c.reglist[r])
+        _, _ = fmt.Fprintf(c.outfile, "\tshrq\t%%rax\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tandl\t$1, %s\n", c.dreglist[r])
+        _, _ = fmt.Fprintf(c.outfile, "\torq\t%s, %%rax\n", c.reglist[r])
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtsi2%sq\t%%rax, %%xmm0\n", suffix)
+        _, _ = fmt.Fprintf(c.outfile, "\tadd%s\t%%xmm0, %%xmm0\n", suffix)
+        c.cglabel(Lend)
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtsi2%sq\t%s, %%xmm0\n", suffix, c.reglist[r])
+    }
+    c.cgfromxmm(r, newtype)
+    return r
+}
+
+// 浮点数转换为整数，小数部分截断。不小于2^63的值转换为uint64时先减去2^63，转换后再置最高位
+func (c *Cgen) cgftoi(r int, oldtype Type, newtype Type) int {
+    c.cgtoxmm(r, "%xmm0")
+    if oldtype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtss2sd\t%%xmm0, %%xmm0\n")
+    }
+    if isUnsigned(newtype) && typeSize(newtype) == 8 {
+        Lbig := c.genLabel()
+        Lend := c.genLabel()
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t$%d, %%rax\n", int(math.Float64bits(1 << 63)))
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %%xmm1\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tucomisd\t%%xmm1, %%xmm0\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tjae\t.L%d\n", Lbig)
+        _, _ = fmt.Fprintf(c.outfile, "\tcvttsd2siq\t%%xmm0, %s\n", c.reglist[r])
+        c.cgjump(Lend)
+        c.cglabel(Lbig)
+        _, _ = fmt.Fprintf(c.outfile, "\tsubsd\t%%xmm1, %%xmm0\n")
+        _, _ = fmt.Fprintf(c.outfile, "\tcvttsd2siq\t%%xmm0, %s\n", c.reglist[r])
+        _, _ = fmt.Fprintf(c.outfile, "\tbtcq\t$63, %s\n", c.reglist[r])
+        c.cglabel(Lend)
+        return r
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tcvttsd2siq\t%%xmm0, %s\n", c.reglist[r])
+    return c.cgextend(r, newtype)
+}
+
+// float32与float64之间的转换
+func (c *Cgen) cgftof(r int, newtype Type) int {
+    c.cgtoxmm(r, "%xmm0")
+    if newtype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtsd2ss\t%%xmm0, %%xmm0\n")
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtss2sd\t%%xmm0, %%xmm0\n")
+    }
+    c.cgfromxmm(r, newtype)
+    return r
+}
+
+// 打印浮点数，float32先转换为float64，%edi告知运行时按float32的精度打印
+func (c *Cgen) cgprintfloat(r int, vartype Type) {
+    c.cgtoxmm(r, "%xmm0")
+    if vartype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tcvtss2sd\t%%xmm0, %%xmm0\n")
+    }
+    c.free_register(r)
+    saved := c.cgsavelive()
+    if vartype == VAR_FLOAT32 {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovl\t$1, %%edi\n")
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovl\t$0, %%edi\n")
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tcall\tmygo.printfloat\n")
+    c.cgrestorelive(saved)
+}
+
+// 打印，无符号数按无符号格式打印
+func (c *Cgen) cgprintint(r int, vartype Type) {
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rdi\n", c.reglist[r])
+    c.free_register(r)
+    saved := c.cgsavelive()
+    if isUnsigned(vartype) {
+        _, _ = fmt.Fprintf(c.outfile, "\tcall\tmygo.printuint\n")
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tcall\tmygo.printint\n")
+    }
+    c.cgrestorelive(saved)
+}
+
+// 类型为vartype的零值，字符串的零值为空字符串
+func (c *Cgen) cgloadzero(vartype Type) int {
+    if vartype == VAR_STRING {
+        return c.cgloadstring("")
+    }
+    return c.cgloadint(0)
+}
+
+// 字符串常量s的字符串头的标签，相同的常量共用一个字符串头
+func (c *Cgen) strlabel(s string) int {
+    for _, lit := range c.strlits {
+        if lit.value == s {
+            return lit.hdr
+        }
+    }
+    lit := strLiteral{value: s, hdr: c.genLabel(), data: c.genLabel()}
+    c.strlits = append(c.strlits, lit)
+    return lit.hdr
+}
+
+// 加载字符串常量：寄存器中保存字符串头的地址
+func (c *Cgen) cgloadstring(s string) int {
+    r := c.alloc_register()
+    _, _ = fmt.Fprintf(c.outfile, "\tleaq\t.L%d(%%rip), %s\n", c.strlabel(s), c.reglist[r])
+    return r
+}
+
+// 字符串的长度
+func (c *Cgen) cgstrlen(r int) int {
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t8(%s), %s\n", c.reglist[r], c.reglist[r])
+    return r
+}
+
+// 字符串r1的第r2个字节。下标按无符号数与长度比较，负数也会越界
+func (c *Cgen) cgindex(r1, r2 int) int {
+    Lok := c.genLabel()
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t8(%s), %%rax\n", c.reglist[r1])
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t%%rax, %s\n", c.reglist[r2])
+    _, _ = fmt.Fprintf(c.outfile, "\tjb\t.L%d\n", Lok)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rdi\n", c.reglist[r2])
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %%rsi\n")
+    _, _ = fmt.Fprintf(c.outfile, "\tcall\tmygo.panicindex\n")  // 不返回
+    c.cglabel(Lok)
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t(%s), %s\n", c.reglist[r1], c.reglist[r1])
+    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t(%s,%s), %s\n", c.reglist[r1], c.reglist[r2], c.reglist[r1])
     c.free_register(r2)
     return r1
 }
 
-// 打印
-func (c *Cgen) cgprintint(r int) {
+// 比较字符串r1和r2，结果为1或0。strcompare的结果与0比较，小于、等于、大于0分别表示r1小于、等于、大于r2
+func (c *Cgen) cgscompare_and_set(r1 int, r2 int, how Token) int {
+    set, ok := cmpdict[how]
+    if !ok {
+        c.error("unsupported compare token")
+    }
+    r := c.cgcallruntime("mygo.strcompare", r1, r2)
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$0, %s\n", c.reglist[r])
+    _, _ = fmt.Fprintf(c.outfile, "\t%s\t%s\n", set, c.breglist[r])
+    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s, %s\n", c.breglist[r], c.reglist[r])
+    return r
+}
+
+// 打印字符串
+func (c *Cgen) cgprintstring(r int) {
     _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rdi\n", c.reglist[r])
-    _, _ = fmt.Fprintf(c.outfile, "\tcall\tprintint\n")
     c.free_register(r)
+    saved := c.cgsavelive()
+    _, _ = fmt.Fprintf(c.outfile, "\tcall\tmygo.printstring\n")
+    c.cgrestorelive(saved)
+}
+
+// 调用汇编头中的运行时函数name，寄存器regs依次作为实参并被释放，返回保存结果的寄存器
+func (c *Cgen) cgcallruntime(name string, regs ...int) int {
+    for i, r := range regs {
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s\n", c.reglist[r], argreglist[i])
+    }
+    for i := len(regs) - 1; i >= 0; i-- {
+        c.free_register(regs[i])
+    }
+    saved := c.cgsavelive()
+    _, _ = fmt.Fprintf(c.outfile, "\tcall\t%s\n", name)
+    c.cgrestorelive(saved)
+    r := c.alloc_register()
+    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %s\n", c.reglist[r])
+    return r
+}
+
+// 用户的函数和全局变量在汇编中的名字。加上包名前缀，以免与C库和运行时的符号冲突
+func (c *Cgen) symname(id int) string {
+    return "main." + c.sym.symbles[id].Name
 }
 
 // 加载变量
 func (c *Cgen) cgloadglob(id int) int {
     r := c.alloc_register()
-    switch Gsym.symbles[id].Vartype {
-    case VAR_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s(%%rip), %s\n", Gsym.symbles[id].Name, c.reglist[r])
-    case VAR_INT, VAR_POINTER_INT, VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s(%%rip), %s\n", Gsym.symbles[id].Name, c.reglist[r])
-    default:
-        c.error("Error: unspported vartype")
-    }
+    c.cgload(r, c.sym.symbles[id].Vartype, c.symname(id) + "(%rip)")
     return r
 }
 
 // 变量赋值
 func (c *Cgen) cgstoreglob(r int, id int) int {
-    switch Gsym.symbles[id].Vartype {
-    case VAR_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovb\t%s, %s(%%rip)\n", c.breglist[r], Gsym.symbles[id].Name)
-    case VAR_INT, VAR_POINTER_INT, VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s(%%rip)\n", c.reglist[r], Gsym.symbles[id].Name)
-    default:
-        c.error("Error: unspported vartype")
-    }
+    c.cgstore(r, c.sym.symbles[id].Vartype, c.symname(id) + "(%rip)")
     return r
 }
 
-// 创建变量
-func (c *Cgen) cgglobsym(id int) {
+// 创建变量，init为静态的初始值（常量表达式或全局变量的地址），nil时为零值
+func (c *Cgen) cgglobsym(id int, init *ASTNode) {
+    value := "0"
+    vartype := c.sym.symbles[id].Vartype
+    if vartype == VAR_STRING {
+        v := ""  // 零值为空字符串
+        if init != nil {
+            var ok bool
+            if v, ok = stringValue(init); !ok {
+                c.pos = init.pos
+                c.error("global initializer must be a constant expression")
+            }
+        }
+        value = fmt.Sprintf(".L%d", c.strlabel(v))
+    } else if init != nil {
+        if f, ok := floatValue(init); ok && vartype == VAR_FLOAT32 {
+            value = fmt.Sprint(math.Float32bits(float32(f)))  // 浮点数保存它的二进制表示
+        } else if ok && isFloat(vartype) {
+            value = fmt.Sprint(math.Float64bits(f))
+        } else if v, ok := constValue(init); ok {
+            value = fmt.Sprint(v)
+        } else if init.nodeKind == UnaryOpK && init.token == AMPER {
+            value = c.symname(init.symbleid)
+        } else {
+            c.pos = init.pos
+            c.error("global initializer must be a constant expression")
+        }
+    }
     _, _ = fmt.Fprintf(c.outfile, "\t.data\n")
-    _, _ = fmt.Fprintf(c.outfile, "\t.globl\t%s\n", Gsym.symbles[id].Name)
-    _, _ = fmt.Fprintf(c.outfile, "%s:", Gsym.symbles[id].Name)
-    switch Gsym.symbles[id].Vartype {
-    case VAR_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\t.byte\t0\n")
-    case VAR_INT, VAR_POINTER_INT, VAR_POINTER_CHAR:
-       // _, _ = fmt.Fprintf(c.outfile, "\t.comm\t%s,8,8\n", Gsym.symbles[id].Name)
-        _, _ = fmt.Fprintf(c.outfile, "\t.quad\t0\n")
+    _, _ = fmt.Fprintf(c.outfile, "\t.globl\t%s\n", c.symname(id))
+    _, _ = fmt.Fprintf(c.outfile, "%s:", c.symname(id))
+    switch typeSize(c.sym.symbles[id].Vartype) {
+    case 1:
+        _, _ = fmt.Fprintf(c.outfile, "\t.byte\t%s\n", value)
+    case 2:
+        _, _ = fmt.Fprintf(c.outfile, "\t.word\t%s\n", value)
+    case 4:
+        _, _ = fmt.Fprintf(c.outfile, "\t.long\t%s\n", value)
+    case 8:
+        _, _ = fmt.Fprintf(c.outfile, "\t.quad\t%s\n", value)
     default:
-        c.error("Error: unspported vartype")
+        c.error("unsupported variable type")
     }
 }
 
-// 寄存器变量的类型转换
-func (c *Cgen) cgwiden(r int, oldtype int, newtype int) int {
+// 寄存器变量的类型转换：oldtype的值转换为newtype。整数之间转换时，
+// 不足8字节的类型截断后重新扩展；涉及浮点数时用cvt指令转换
+func (c *Cgen) cgwiden(r int, oldtype Type, newtype Type) int {
+    switch {
+    case oldtype == newtype:
+        return r
+    case isFloat(oldtype) && isFloat(newtype):
+        return c.cgftof(r, newtype)
+    case isFloat(newtype):
+        return c.cgitof(r, oldtype, newtype)
+    case isFloat(oldtype):
+        return c.cgftoi(r, oldtype, newtype)
+    }
+    return c.cgextend(r, newtype)
+}
+
+// 将寄存器r截断为类型t的宽度，再按t的符号扩展为64位。
+// 寄存器中的值总是扩展后的64位形式，运算结果可能超出类型的范围时需要重新扩展
+func (c *Cgen) cgextend(r int, t Type) int {
+    if size := typeSize(t); size > 0 && size < 8 {
+        c.cgmovext(c.regname(r, size), r, t)
+    }
     return r
 }
 
+// 将src（内存地址或寄存器）中类型为t的值按t的符号扩展后放入寄存器r
+func (c *Cgen) cgmovext(src string, r int, t Type) {
+    switch size := typeSize(t); {
+    case size == 4 && !isSigned(t):
+        // 写入32位寄存器时高32位自动清零
+        _, _ = fmt.Fprintf(c.outfile, "\tmovl\t%s, %s\n", src, c.dreglist[r])
+    case size < 8:
+        ext := "movz"
+        if isSigned(t) {
+            ext = "movs"
+        }
+        _, _ = fmt.Fprintf(c.outfile, "\t%s%sq\t%s, %s\n", ext, movsuffix[size], src, c.reglist[r])
+    default:
+        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s\n", src, c.reglist[r])
+    }
+}
+
+// 寄存器r的低size字节
+func (c *Cgen) regname(r int, size int) string {
+    switch size {
+    case 1:
+        return c.breglist[r]
+    case 2:
+        return c.wreglist[r]
+    case 4:
+        return c.dreglist[r]
+    }
+    return c.reglist[r]
+}
+
+// 从内存地址addr加载类型为t的值到寄存器r
+func (c *Cgen) cgload(r int, t Type, addr string) {
+    if typeSize(t) == 0 {
+        c.error("unsupported variable type")
+    }
+    c.cgmovext(addr, r, t)
+}
+
+// 将寄存器r中类型为t的值存入内存地址addr，只写入t的宽度
+func (c *Cgen) cgstore(r int, t Type, addr string) {
+    size := typeSize(t)
+    if size == 0 {
+        c.error("unsupported variable type")
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tmov%s\t%s, %s\n", movsuffix[size], c.regname(r, size), addr)
+}
+
 // 比较并设置
 var cmpdict = map[Token]string{
     EQ: "sete",
@@ -431,26 +1607,41 @@ var cmpdict = map[Token]string{
     NE: "setne",
 }
 
-func (c *Cgen) cgcompare_and_set(r1 int, r2 int, how Token) int {
-    set, ok := cmpdict[how]
+// 无符号数比较并设置
+var ucmpdict = map[Token]string{
+    EQ: "sete",
+    GT: "seta",
+    LT: "setb",
+    LE: "setbe",
+    GE: "setae",
+    NE: "setne",
+}
+
+// 比较类型为vartype的r1和r2，结果为1或0
+func (c *Cgen) cgcompare_and_set(r1 int, r2 int, how Token, vartype Type) int {
+    dict := cmpdict
+    if isUnsigned(vartype) {
+        dict = ucmpdict
+    }
+    set, ok := dict[how]
     if !ok {
-        panic("Error: unspported compare token")
+        c.error("unsupported compare token")
     }
     _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
-    _, _ = fmt.Fprintf(c.outfile, "\t%s\t%s\n", set, c.breglist[r2])
-    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s, %s\n", c.breglist[r2], c.reglist[r2])
-    c.free_register(r1)
-    return r2
+    _, _ = fmt.Fprintf(c.outfile, "\t%s\t%s\n", set, c.breglist[r1])
+    _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%s, %s\n", c.breglist[r1], c.reglist[r1])
+    c.free_register(r2)
+    return r1
 }
 
 // 生成一个标签
 func (c *Cgen) cglabel(l int) {
-    _, _ = fmt.Fprintf(c.outfile, "L%d:\n", l)
+    _, _ = fmt.Fprintf(c.outfile, ".L%d:\n", l)
 }
 
 // 跳转到一个标签
 func (c *Cgen) cgjump(l int) {
-    _, _ = fmt.Fprintf(c.outfile, "\tjmp\tL%d\n", l)
+    _, _ = fmt.Fprintf(c.outfile, "\tjmp\t.L%d\n", l)
 }
 
 // 比较并在false时跳转
@@ -463,99 +1654,207 @@ var jumpdict = map[Token]string{
     NE: "je",
 }
 
-func (c *Cgen) cgcompare_and_jump(r1 int, r2 int, how Token, label int) int {
-    set, ok := jumpdict[how]
+// 比较并在true时跳转
+var jumptruedict = map[Token]string{
+    EQ: "je",
+    GT: "jg",
+    LT: "jl",
+    GE: "jge",
+    LE: "jle",
+    NE: "jne",
+}
+
+// 无符号数比较并在false时跳转
+var ujumpdict = map[Token]string{
+    EQ: "jne",
+    GT: "jbe",
+    LT: "jae",
+    GE: "jb",
+    LE: "ja",
+    NE: "je",
+}
+
+// 无符号数比较并在true时跳转
+var ujumptruedict = map[Token]string{
+    EQ: "je",
+    GT: "ja",
+    LT: "jb",
+    GE: "jae",
+    LE: "jbe",
+    NE: "jne",
+}
+
+// 比较类型为vartype的r1和r2，比较结果为cond时跳转到label。
+// 寄存器在跳转前释放（恢复溢出的寄存器不影响标志位），跳转前后寄存器的状态一致
+func (c *Cgen) cgcompare_and_jump(r1 int, r2 int, how Token, vartype Type, label int, cond bool) {
+    var dict map[Token]string
+    switch {
+    case isUnsigned(vartype) && cond:
+        dict = ujumptruedict
+    case isUnsigned(vartype):
+        dict = ujumpdict
+    case cond:
+        dict = jumptruedict
+    default:
+        dict = jumpdict
+    }
+    jump, ok := dict[how]
     if !ok {
-        panic("Error: unspported jump token")
+        c.error("unsupported jump token")
     }
     _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t%s, %s\n", c.reglist[r2], c.reglist[r1])
-    _, _ = fmt.Fprintf(c.outfile, "\t%s\tL%d\n", set, label)
-    c.freeall_registers()
-    return -1
+    c.free_register(r2)
+    c.free_register(r1)
+    _, _ = fmt.Fprintf(c.outfile, "\t%s\t.L%d\n", jump, label)
 }
 
-// 函数调用
-func (c *Cgen) cgcall(r int, id int) int {
-    outr := c.alloc_register()
-    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rdi\n", c.reglist[r])
-    //_, _ = fmt.Fprintf(c.outfile, "\tpushq\t%s\n", c.reglist[r])
-    _, _ = fmt.Fprintf(c.outfile, "\tcall\t%s\n", Gsym.symbles[id].Name)
-    _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %s\n", c.reglist[outr])
+// 值不为0视为true，为cond时跳转到label
+func (c *Cgen) cgtest_and_jump(r int, label int, cond bool) {
+    _, _ = fmt.Fprintf(c.outfile, "\tcmpq\t$0, %s\n", c.reglist[r])
     c.free_register(r)
-    return outr
+    if cond {
+        _, _ = fmt.Fprintf(c.outfile, "\tjne\t.L%d\n", label)
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tje\t.L%d\n", label)
+    }
 }
 
-// 函数返回一个值
-func (c *Cgen) cgreturn(r int, id int) {
-    switch Gsym.symbles[id].ReturnType {
-    case VAR_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovzbl\t%s, %%eax\n", c.breglist[r])
-    case VAR_INT:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %%rax\n", c.reglist[r])
+// 函数调用：nargs个实参已按顺序保存在最近的临时槽中。
+// 前6个实参通过寄存器传递，其余实参从右到左压栈，调用时%rsp保持16字节对齐。
+// 第1、2个返回值由%rax、%rdx传回，其余返回值由被调用者写入调用者在栈参数之上预留的空间。
+// results非空时，全部返回值依次保存到results中的临时槽，否则第一个返回值留在%rax中
+func (c *Cgen) cgcall(nargs int, id int, results []int) {
+    // 先确定实参所在的临时槽，保存活跃寄存器会占用新的临时槽
+    top := c.topslot()
+    argslot := func(i int) int {
+        return top + 8*(nargs-1-i)
+    }
+    saved := c.cgsavelive()
+    types := c.sym.ParamTypes(id)
+    resulttypes := c.sym.symbles[id].Results
+    stackargs := stackcount(types, argloc)
+    extra := stackcount(resulttypes, resultloc)
+    pad := 8 * ((stackargs + extra) % 2)
+    if pad + 8*extra != 0 {
+        _, _ = fmt.Fprintf(c.outfile, "\tsubq\t$%d, %%rsp\n", pad + 8*extra)
+    }
+    for i := nargs - 1; i >= 0; i-- {
+        if _, stack := argloc(types, i); stack != -1 {
+            _, _ = fmt.Fprintf(c.outfile, "\tpushq\t%d(%%rbp)\n", argslot(i))
+        }
+    }
+    for i := 0; i < nargs; i++ {
+        if reg, _ := argloc(types, i); reg != -1 && isFloat(types[i]) {
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", argslot(i), argxmmlist[reg])
+        } else if reg != -1 {
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", argslot(i), argreglist[reg])
+        }
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tcall\t%s\n", c.symname(id))
+    for i, slot := range results {
+        reg, stack := resultloc(resulttypes, i)
+        switch {
+        case reg != -1 && isFloat(resulttypes[i]):
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", resultxmmlist[reg], slot)
+        case reg != -1:
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", resultreglist[reg], slot)
+        default:
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rsp), %%rax\n", 8*(stackargs+stack))
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%%rax, %d(%%rbp)\n", slot)
+        }
+    }
+    if stackargs + extra != 0 || pad != 0 {
+        _, _ = fmt.Fprintf(c.outfile, "\taddq\t$%d, %%rsp\n", 8*(stackargs+extra) + pad)
+    }
+    c.cgrestorelive(saved)
+    for i := 0; i < nargs; i++ {
+        c.popslot()
+    }
+}
+
+// 将第i个寄存器传入的形参保存到栈帧，浮点数由第i个%xmm寄存器传入
+func (c *Cgen) cgstoreparam(i int, id int) {
+    var reg string
+    size := typeSize(c.sym.symbles[id].Vartype)
+    if isFloat(c.sym.symbles[id].Vartype) {
+        mov := "movq"
+        if size == 4 {
+            mov = "movd"
+        }
+        _, _ = fmt.Fprintf(c.outfile, "\t%s\t%s, %d(%%rbp)\n", mov, argxmmlist[i], c.sym.symbles[id].Offset)
+        return
+    }
+    switch size {
+    case 1:
+        reg = argbreglist[i]
+    case 2:
+        reg = argwreglist[i]
+    case 4:
+        reg = argdreglist[i]
+    case 8:
+        reg = argreglist[i]
     default:
-        c.error("Error: undefined return type")
+        c.error("unsupported parameter type")
+    }
+    _, _ = fmt.Fprintf(c.outfile, "\tmov%s\t%s, %d(%%rbp)\n", movsuffix[size], reg, c.sym.symbles[id].Offset)
+}
+
+// 函数返回：前两个整数返回值放入%rax、%rdx，前两个浮点数返回值放入%xmm0、%xmm1，
+// 其余返回值写入调用者预留的栈空间，位于栈参数之上。随后跳转到函数尾
+func (c *Cgen) cgreturn(regs []int, id int) {
+    fn := &c.sym.symbles[id]
+    stackparams := stackcount(c.sym.ParamTypes(id), argloc)
+    for i := len(regs) - 1; i >= 0; i-- {
+        r := regs[i]
+        if typeSize(fn.Results[i]) == 0 {
+            c.error("unsupported return type")
+        }
+        reg, stack := resultloc(fn.Results, i)
+        switch {
+        case reg != -1 && isFloat(fn.Results[i]):
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s\n", c.reglist[r], resultxmmlist[reg])
+        case reg != -1:
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %s\n", c.reglist[r], resultreglist[reg])
+        default:
+            _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[r], 16+8*(stackparams+stack))
+        }
+        c.free_register(r)
     }
-    c.cgjump(Gsym.symbles[id].EndLabel)
+    c.cgjump(fn.EndLabel)
 }
 
 // 指针：获取变量地址
 func (c *Cgen) cgaddress(id int) int {
     r := c.alloc_register()
-    _, _ = fmt.Fprintf(c.outfile, "\tleaq\t%s(%%rip), %s\n", Gsym.symbles[id].Name, c.reglist[r])
+    if c.sym.symbles[id].IsLocal {
+        _, _ = fmt.Fprintf(c.outfile, "\tleaq\t%d(%%rbp), %s\n", c.sym.symbles[id].Offset, c.reglist[r])
+    } else {
+        _, _ = fmt.Fprintf(c.outfile, "\tleaq\t%s(%%rip), %s\n", c.symname(id), c.reglist[r])
+    }
     return r
 }
 
-// 指针：获取值
+// 指针：获取寄存器r指向的类型为vartype的值
 func (c *Cgen) cgderef(r int, vartype Type) int {
-    switch vartype {
-    case VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t(%s), %s\n", c.reglist[r], c.reglist[r])
-    case VAR_POINTER_INT:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t(%s), %s\n", c.reglist[r], c.reglist[r])
-    default:
-        c.error("not supported pointer type")
-    }
+    c.cgload(r, vartype, "(" + c.reglist[r] + ")")
     return r
 }
 
-// 指针：r1赋值到r2指针
+// 指针：r1赋值到r2指向的类型为vartype的变量
 func (c *Cgen) cgstorederef(r1 int, r2 int, vartype Type) int {
-    switch vartype {
-    case VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovb\t%s, (%s)\n", c.breglist[r1], c.reglist[r2])
-    case VAR_POINTER_INT:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, (%s)\n", c.reglist[r1], c.reglist[r2])
-    default:
-        c.error("Error: undefined local type")
-    }
+    c.cgstore(r1, vartype, "(" + c.reglist[r2] + ")")
     return r1
 }
 
 // 加载局部变量
 func (c *Cgen) cgloadlocal(id int) int {
     r := c.alloc_register()
-    switch Gsym.symbles[id].Vartype {
-    case VAR_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovzbq\t%d(%%rbp), %s\n", Gsym.symbles[id].Offset, c.reglist[r])
-    case VAR_INT, VAR_POINTER_INT, VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%d(%%rbp), %s\n", Gsym.symbles[id].Offset, c.reglist[r])
-    default:
-        c.error("Error: undefined local type")
-    }
+    c.cgload(r, c.sym.symbles[id].Vartype, fmt.Sprintf("%d(%%rbp)", c.sym.symbles[id].Offset))
     return r
 }
 
 // 局部变量赋值
 func (c *Cgen) cgstorelocal(r int, id int) int {
-    switch Gsym.symbles[id].Vartype {
-    case VAR_CHAR:
-        //_, _ = fmt.Fprintf(c.outfile, "\tpushl\t%s\n", c.breglist[r])
-        _, _ = fmt.Fprintf(c.outfile, "\tmovb\t%s, %d(%%rbp)\n", c.breglist[r], Gsym.symbles[id].Offset)
-    case VAR_INT, VAR_POINTER_INT, VAR_POINTER_CHAR:
-        _, _ = fmt.Fprintf(c.outfile, "\tmovq\t%s, %d(%%rbp)\n", c.reglist[r], Gsym.symbles[id].Offset)
-        //_, _ = fmt.Fprintf(c.outfile, "\tpushq\t%s\n", c.reglist[r])
-    default:
-        c.error("Error: undefined local type")
-    }
+    c.cgstore(r, c.sym.symbles[id].Vartype, fmt.Sprintf("%d(%%rbp)", c.sym.symbles[id].Offset))
     return r
-}
\ No newline at end of file
+}
@@ -0,0 +1,885 @@
+package compiler
+
+import (
+    "fmt"
+    "math"
+    "math/big"
+    "strconv"
+)
+
+// Checker 语义分析：在语法分析之后、代码生成之前检查语法树中的类型，
+// 为每个表达式节点标注类型。名字的解析和值的个数已由语法分析检查
+type Checker struct {
+    tree *ASTNode
+    fn   int  // 当前函数的插槽id
+
+    ctx *Compilation
+    sym *Symtable
+}
+
+func NewChecker(ctx *Compilation, tree *ASTNode) *Checker {
+    return &Checker{
+        ctx:  ctx,
+        sym:  ctx.Sym,
+        tree: tree,
+        fn:   -1,
+    }
+}
+
+// Check 检查整个语法树，出错时返回DiagnosticList
+func (c *Checker) Check() (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(tooManyErrors); !ok {
+                panic(r)
+            }
+            err = c.ctx.Err()
+        }
+    }()
+
+    c.stmts(c.tree)
+    return c.ctx.Err()
+}
+
+// 在位置pos处记录一个错误。与语法分析相同，同一行只保留第一个错误
+func (c *Checker) report(pos Pos, msg string) {
+    if n := len(c.ctx.diags); n > 0 {
+        last := c.ctx.diags[n-1].Pos
+        if last.File == pos.File && last.Line == pos.Line {
+            return
+        }
+    }
+    if c.ctx.errorCount() >= c.ctx.Opts.maxErrors() {
+        c.ctx.addDiagnostic(&Diagnostic{Severity: SevError, Pos: pos, Msg: "too many errors"})
+        panic(tooManyErrors{})
+    }
+    c.ctx.addDiagnostic(&Diagnostic{Severity: SevError, Pos: pos, Msg: msg})
+}
+
+////////////////////////////////// 语句 ////////////////////////////
+// 检查语句序列
+func (c *Checker) stmts(t *ASTNode) {
+    for ; t != nil; t = t.sibling {
+        c.stmt(t)
+    }
+}
+
+func (c *Checker) stmt(t *ASTNode) {
+    switch t.nodeKind {
+    case FuncK:
+        c.fn = t.symbleid
+        c.stmts(t.child[1])
+        if len(c.sym.symbles[t.symbleid].Results) > 0 && !terminates(t.child[1]) {
+            c.report(t.end, "missing return")
+        }
+        c.fn = -1
+    case VarK:
+        c.assign(t.child[0], t.child[1], "variable declaration")
+    case AssignK:
+        c.assign(t.child[0], t.child[1], "assignment")
+    case PrintK:
+        c.value(t.child[0])
+    case IfK:
+        if t.child[3] != nil {
+            c.stmt(t.child[3])
+        }
+        c.cond(t.child[0], "if")
+        c.stmts(t.child[1])
+        c.stmts(t.child[2])
+    case ForK:
+        if t.child[2] != nil {
+            c.stmt(t.child[2])
+        }
+        if t.child[0] != nil {
+            c.cond(t.child[0], "for")
+        }
+        if t.child[3] != nil {
+            c.stmt(t.child[3])
+        }
+        c.stmts(t.child[1])
+    case ReturnK:
+        c.returns(t)
+    case LabelK:
+        if t.child[0] != nil {
+            c.stmt(t.child[0])
+        }
+    case BlockK:
+        c.stmts(t.child[0])
+    case CallK:
+        c.call(t)
+    case BreakK, ContinueK, GotoK:
+    default:
+        c.expr(t)
+    }
+}
+
+// 语句序列是否以终止语句结束：return、goto，两个分支都终止的if，
+// 没有条件也没有跳出它的break的for，以及以终止语句结束的语句块
+func terminates(list *ASTNode) bool {
+    if list == nil {
+        return false
+    }
+    for list.sibling != nil {
+        list = list.sibling
+    }
+    switch t := list; t.nodeKind {
+    case ReturnK, GotoK:
+        return true
+    case BlockK:
+        return terminates(t.child[0])
+    case IfK:
+        return t.child[2] != nil && terminates(t.child[1]) && terminates(t.child[2])
+    case ForK:
+        return t.child[0] == nil && !hasBreak(t.child[1], t.litval, true)
+    case LabelK:
+        return t.child[0] != nil && terminates(t.child[0])
+    }
+    return false
+}
+
+// 语句序列中是否有跳出标签为label的循环的break。inner为true时，
+// 不带标签的break也跳出该循环（不在内层的循环中）
+func hasBreak(list *ASTNode, label string, inner bool) bool {
+    for t := list; t != nil; t = t.sibling {
+        switch t.nodeKind {
+        case BreakK:
+            if t.litval == "" && inner || t.litval != "" && t.litval == label {
+                return true
+            }
+        case BlockK:
+            if hasBreak(t.child[0], label, inner) {
+                return true
+            }
+        case IfK:
+            if hasBreak(t.child[1], label, inner) || hasBreak(t.child[2], label, inner) {
+                return true
+            }
+        case ForK:
+            if label != "" && hasBreak(t.child[1], label, false) {
+                return true
+            }
+        case LabelK:
+            if t.child[0] != nil && hasBreak(t.child[0], label, inner) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// 检查if或for语句的条件
+func (c *Checker) cond(t *ASTNode, what string) {
+    if typ := c.value(t); typ != VAR_INVALID && !isBoolean(typ) {
+        c.report(t.pos, fmt.Sprintf("non-boolean condition in %s statement", what))
+    }
+}
+
+// 检查将值列表rhs赋给变量列表lhs，context为出错时的说明
+func (c *Checker) assign(lhs, rhs *ASTNode, context string) {
+    if rhs == nil {
+        return  // 没有初始值的变量声明
+    }
+    if call := rhs; call.sibling == nil && call.nodeKind == CallK && lhs.sibling != nil {
+        // 多返回值的函数调用，返回值的个数已由语法分析检查
+        c.call(call)
+        results := c.sym.symbles[call.symbleid].Results
+        i := 0
+        for n := lhs; n != nil && i < len(results); n, i = n.sibling, i+1 {
+            if T := c.lvalue(n); !assignable(results[i], T) {
+                c.report(call.pos, fmt.Sprintf("cannot use %s (value of type %s) as %s value in %s",
+                    exprString(call), results[i], T, context))
+            }
+        }
+        return
+    }
+    for n, e := lhs, rhs; n != nil && e != nil; n, e = n.sibling, e.sibling {
+        c.convert(e, c.lvalue(n), context)
+    }
+}
+
+// 被赋值的变量或*p的类型
+func (c *Checker) lvalue(t *ASTNode) Type {
+    if t.nodeKind == IdK {
+        t.vartype = c.sym.symbles[t.symbleid].Vartype
+        return t.vartype
+    }
+    return c.expr(t)
+}
+
+// 检查return语句的返回值与当前函数的返回值类型
+func (c *Checker) returns(t *ASTNode) {
+    results := c.sym.symbles[c.fn].Results
+    e := t.child[0]
+    if e != nil && e.sibling == nil && e.nodeKind == CallK && len(c.sym.symbles[e.symbleid].Results) > 1 {
+        // return f()，f的返回值个数已由语法分析检查
+        c.call(e)
+        for i, typ := range c.sym.symbles[e.symbleid].Results {
+            if i < len(results) && !assignable(typ, results[i]) {
+                c.report(e.pos, fmt.Sprintf("cannot use %s (value of type %s) as %s value in return statement",
+                    exprString(e), typ, results[i]))
+            }
+        }
+        return
+    }
+    for i := 0; e != nil && i < len(results); e, i = e.sibling, i+1 {
+        c.convert(e, results[i], "return statement")
+    }
+}
+
+////////////////////////////////// 表达式 ////////////////////////////
+// 检查作为值使用的表达式t，未定类型的常量转换为默认类型
+func (c *Checker) value(t *ASTNode) Type {
+    typ := defaultType(c.expr(t))
+    if constRepresentable(t, typ) != "" {
+        c.report(t.pos, fmt.Sprintf("%s overflows %s", c.operand(t), typ))
+        return VAR_INVALID
+    }
+    c.settype(t, typ)
+    return typ
+}
+
+// 检查表达式t能否赋给类型为T的变量，并将未定类型的常量转换为T
+func (c *Checker) convert(t *ASTNode, T Type, context string) {
+    typ := c.expr(t)
+    if typ == VAR_INVALID || T == VAR_INVALID {
+        return
+    }
+    if !assignable(typ, T) {
+        c.report(t.pos, fmt.Sprintf("cannot use %s as %s value in %s", c.operand(t), T, context))
+        return
+    }
+    if cause := constRepresentable(t, T); cause != "" {
+        c.report(t.pos, fmt.Sprintf("cannot use %s as %s value in %s (%s)", c.operand(t), T, context, cause))
+        return
+    }
+    if isUntyped(typ) {
+        c.settype(t, T)
+    }
+}
+
+// 将未定类型的表达式t及其中的未定类型的操作数标注为类型T
+func (c *Checker) settype(t *ASTNode, T Type) {
+    if !isUntyped(t.vartype) {
+        return
+    }
+    if t.vartype == UNTYPED_STRING && t.nodeKind != ConstK {
+        // 字符串常量的拼接在编译时完成
+        t.litval, _ = stringValue(t)
+        t.nodeKind, t.token, t.child = ConstK, STRLIT, nil
+    }
+    if (isFloat(T) || t.vartype == UNTYPED_FLOAT) && T != t.vartype && fold(t, T) {
+        return  // 常量在整数与浮点数之间转换，或者确定了浮点数类型
+    }
+    if t.nodeKind != ConstK && !isUntyped(T) {
+        // 未定类型的整数常量可以超出64位，按精确值计算后替换为常量
+        if t.vartype == UNTYPED_INT && isInteger(T) && fold(t, T) {
+            return
+        }
+        if v, ok := intValue(t); ok && t.vartype == UNTYPED_BOOL {
+            token := FALSE
+            if v.Sign() != 0 {
+                token = TRUE
+            }
+            foldInt(t, v, token, T)
+            return
+        }
+    }
+    t.vartype = T
+    switch t.nodeKind {
+    case UnaryOpK:
+        c.settype(t.child[0], T)
+    case OpK:
+        switch t.token {
+        case SHL, SHR:
+            c.settype(t.child[0], T)
+        case EQ, NE, LT, LE, GT, GE:
+            // 比较运算的操作数的类型与结果无关
+        default:
+            c.settype(t.child[0], T)
+            c.settype(t.child[1], T)
+        }
+    }
+}
+
+// 检查表达式t，返回并标注它的类型
+func (c *Checker) expr(t *ASTNode) Type {
+    t.vartype = c.exprType(t)
+    if (t.nodeKind == OpK || t.nodeKind == UnaryOpK) && !isUntyped(t.vartype) {
+        // 有类型的常量的运算结果也必须能用该类型表示
+        val := ""
+        if isInteger(t.vartype) {
+            if x, ok := intValue(t); ok && !representable(x, t.vartype) {
+                val = x.String()
+            }
+        } else if isFloat(t.vartype) {
+            if x, ok := bigFloatValue(t); ok && floatOverflows(x, t.vartype) {
+                val = x.Text('g', 6)
+            }
+        }
+        if val != "" {
+            c.report(t.pos, fmt.Sprintf("%s (constant %s of type %s) overflows %s", exprString(t), val, t.vartype, t.vartype))
+            t.vartype = VAR_INVALID
+        }
+    }
+    return t.vartype
+}
+
+func (c *Checker) exprType(t *ASTNode) Type {
+    switch t.nodeKind {
+    case ConstK:
+        switch t.token {
+        case TRUE, FALSE:
+            return UNTYPED_BOOL
+        case FNUM:
+            return UNTYPED_FLOAT
+        case STRLIT:
+            return UNTYPED_STRING
+        }
+        return UNTYPED_INT
+    case IdK:
+        typ := c.sym.symbles[t.symbleid].Vartype
+        if typ == VAR_FUNC {
+            c.report(t.pos, fmt.Sprintf("%s (value of type func) is not used", t.litval))
+            return VAR_INVALID
+        }
+        return typ
+    case CallK:
+        c.call(t)
+        if results := c.sym.symbles[t.symbleid].Results; len(results) == 1 {
+            return results[0]
+        }
+        return VAR_INVALID  // 已由语法分析报告
+    case UnaryOpK:
+        return c.unary(t)
+    case OpK:
+        return c.binary(t)
+    case ConvK:
+        return c.conversion(t)
+    case IndexK:
+        return c.index(t)
+    case SliceK:
+        return c.slice(t)
+    case LenK:
+        return c.length(t)
+    }
+    return VAR_INVALID
+}
+
+// 字符串的下标s[i]：结果为byte
+func (c *Checker) index(t *ASTNode) Type {
+    x := t.child[0]
+    typ := c.value(x)
+    if typ == VAR_INVALID {
+        return VAR_INVALID
+    }
+    if !isString(typ) {
+        c.report(x.pos, fmt.Sprintf("invalid operation: cannot index %s", c.operand(x)))
+        return VAR_INVALID
+    }
+    if !c.indexValue(t.child[1], x, 0) {
+        return VAR_INVALID
+    }
+    return VAR_CHAR
+}
+
+// 字符串的切片s[lo:hi]：结果为字符串
+func (c *Checker) slice(t *ASTNode) Type {
+    x := t.child[0]
+    typ := c.value(x)
+    if typ == VAR_INVALID {
+        return VAR_INVALID
+    }
+    if !isString(typ) {
+        c.report(x.pos, fmt.Sprintf("cannot slice %s", c.operand(x)))
+        return VAR_INVALID
+    }
+    for _, i := range t.child[1:] {
+        if i != nil && !c.indexValue(i, x, 1) {
+            return VAR_INVALID
+        }
+    }
+    if t.child[1] == nil || t.child[2] == nil {
+        return VAR_STRING
+    }
+    lo, lok := intValue(t.child[1])
+    hi, hok := intValue(t.child[2])
+    if lok && hok && lo.Cmp(hi) > 0 {
+        c.report(t.child[2].pos, fmt.Sprintf("invalid slice indices: %s < %s", hi, lo))
+        return VAR_INVALID
+    }
+    return VAR_STRING
+}
+
+// 检查字符串x的下标i：必须是整数，常量不能为负数。x是字符串常量时，
+// 常量下标不能超过x的长度减1再加上extra（切片的下标可以等于长度）
+func (c *Checker) indexValue(i *ASTNode, x *ASTNode, extra int) bool {
+    typ := c.expr(i)
+    if typ == VAR_INVALID {
+        return false
+    }
+    if typ == UNTYPED_INT && constRepresentable(i, VAR_INT) != "" {
+        c.report(i.pos, fmt.Sprintf("invalid argument: index %s overflows int", c.operand(i)))
+        return false
+    }
+    if typ == UNTYPED_INT || typ == UNTYPED_FLOAT && constRepresentable(i, VAR_INT) == "" {
+        c.settype(i, VAR_INT)
+        typ = VAR_INT
+    }
+    if !isInteger(typ) {
+        c.report(i.pos, fmt.Sprintf("invalid argument: index %s must be integer", c.operand(i)))
+        return false
+    }
+    v, ok := intValue(i)
+    if !ok {
+        return true
+    }
+    if v.Sign() < 0 {
+        c.report(i.pos, fmt.Sprintf("invalid argument: index %s (constant of type %s) must not be negative", exprString(i), typ))
+        return false
+    }
+    if s, ok := stringValue(x); ok && v.Cmp(big.NewInt(int64(len(s)+extra))) >= 0 {
+        c.report(i.pos, fmt.Sprintf("invalid argument: index %s out of bounds [0:%d]", v, len(s)+extra))
+        return false
+    }
+    return true
+}
+
+// 内置函数len(s)：字符串的字节数
+func (c *Checker) length(t *ASTNode) Type {
+    x := t.child[0]
+    typ := c.value(x)
+    if typ != VAR_INVALID && !isString(typ) {
+        c.report(x.pos, fmt.Sprintf("invalid argument: %s for built-in len", c.operand(x)))
+    }
+    return VAR_INT
+}
+
+// 类型转换T(x)：数值类型之间可以相互转换，常量必须能用T表示
+func (c *Checker) conversion(t *ASTNode) Type {
+    T := basictypes[t.token]
+    x := t.child[0]
+    typ := c.expr(x)
+    if typ == VAR_INVALID {
+        return T
+    }
+    if !(isNumeric(typ) && isNumeric(T)) && !assignable(typ, T) {
+        c.report(x.pos, fmt.Sprintf("cannot convert %s to type %s", c.operand(x), t.token))
+        return T
+    }
+    switch cause := constRepresentable(x, T); {
+    case cause == "overflows" && isInteger(typ):
+        v, _ := intValue(x)
+        c.report(x.pos, fmt.Sprintf("constant %s overflows %s", v, t.token))
+        return T
+    case cause != "":
+        c.report(x.pos, fmt.Sprintf("cannot convert %s to type %s (%s)", c.operand(x), t.token, cause))
+        return T
+    }
+    c.settype(x, T)
+    return T
+}
+
+// 检查函数调用的实参与形参的类型
+func (c *Checker) call(t *ASTNode) {
+    fn := &c.sym.symbles[t.symbleid]
+    a := t.child[0]
+    if a != nil && a.sibling == nil && a.nodeKind == CallK && len(c.sym.symbles[a.symbleid].Results) > 1 {
+        c.call(a)  // f(g())，g的返回值依次作为f的实参
+        for i, typ := range c.sym.symbles[a.symbleid].Results {
+            if i < len(fn.Params) && !assignable(typ, fn.Params[i]) {
+                c.report(a.pos, fmt.Sprintf("cannot use %s (value of type %s) as %s value in argument to %s",
+                    exprString(a), typ, fn.Params[i], t.litval))
+            }
+        }
+        return
+    }
+    for i := 0; a != nil; a, i = a.sibling, i+1 {
+        if i < len(fn.Params) {
+            c.convert(a, fn.Params[i], "argument to " + t.litval)
+        } else {
+            c.value(a)
+        }
+    }
+}
+
+// 一元运算：+x -x ^x !x &x *p
+func (c *Checker) unary(t *ASTNode) Type {
+    x := t.child[0]
+    typ := c.expr(x)
+    if typ == VAR_INVALID {
+        return VAR_INVALID
+    }
+    switch t.token {
+    case AMPER:
+        if !isPointer(typ) && typeSize(typ) != 0 {
+            return pointerTo(typ)
+        }
+        c.report(t.pos, fmt.Sprintf("invalid operation: cannot take address of %s", c.operand(x)))
+    case MUL:
+        if isPointer(typ) {
+            return elemType(typ)
+        }
+        c.report(t.pos, fmt.Sprintf("invalid operation: cannot indirect %s", c.operand(x)))
+    case NOT:
+        if isBoolean(typ) {
+            return typ
+        }
+        c.report(t.pos, fmt.Sprintf("invalid operation: operator ! not defined on %s", c.operand(x)))
+    default:
+        if isInteger(typ) || isFloat(typ) && t.token != XOR {
+            return typ
+        }
+        c.report(t.pos, fmt.Sprintf("invalid operation: operator %s not defined on %s", t.token.String(), c.operand(x)))
+    }
+    return VAR_INVALID
+}
+
+// 二元运算
+func (c *Checker) binary(t *ASTNode) Type {
+    x, y := t.child[0], t.child[1]
+    tx, ty := c.expr(x), c.expr(y)
+    if tx == VAR_INVALID || ty == VAR_INVALID {
+        return VAR_INVALID
+    }
+    op := t.token.String()
+
+    switch t.token {
+    case SHL, SHR:
+        // 移位的结果为左操作数的类型，右操作数可以是任意整数类型
+        if !isInteger(tx) {
+            c.report(t.pos, fmt.Sprintf("invalid operation: shifted operand %s must be integer", c.operand(x)))
+            return VAR_INVALID
+        }
+        if !isInteger(ty) {
+            c.report(t.pos, fmt.Sprintf("invalid operation: shift count %s must be integer", c.operand(y)))
+            return VAR_INVALID
+        }
+        if v, ok := intValue(y); ok && v.Sign() < 0 {
+            c.report(y.pos, fmt.Sprintf("invalid shift count %s (negative)", exprString(y)))
+            return VAR_INVALID
+        } else if ok && isConst(x) && v.Cmp(big.NewInt(shiftBound)) > 0 {
+            c.report(y.pos, fmt.Sprintf("invalid operation: invalid shift count %s", c.operand(y)))
+            return VAR_INVALID
+        }
+        c.settype(y, defaultType(ty))
+        return tx
+    case AND, OR:
+        for _, e := range []*ASTNode{x, y} {
+            if !isBoolean(e.vartype) {
+                c.report(t.pos, fmt.Sprintf("invalid operation: operator %s not defined on %s", op, c.operand(e)))
+                return VAR_INVALID
+            }
+        }
+    }
+
+    // 其余运算的两个操作数的类型必须相同，未定类型的常量转换为另一个操作数的类型
+    typ := tx
+    if isUntyped(tx) && !isUntyped(ty) || tx == UNTYPED_INT && ty == UNTYPED_FLOAT {
+        typ = ty
+    }
+    if !assignable(tx, typ) || !assignable(ty, typ) {
+        c.report(t.pos, fmt.Sprintf("invalid operation: %s (mismatched types %s and %s)", exprString(t), tx, ty))
+        return VAR_INVALID
+    }
+    for _, e := range []*ASTNode{x, y} {
+        if isUntyped(typ) {
+            break
+        }
+        if cause := constRepresentable(e, typ); cause == "truncated" {
+            c.report(e.pos, fmt.Sprintf("%s truncated to %s", c.operand(e), typ))
+            return VAR_INVALID
+        } else if cause != "" {
+            c.report(e.pos, fmt.Sprintf("%s overflows %s", c.operand(e), typ))
+            return VAR_INVALID
+        }
+    }
+    c.settype(x, typ)
+    c.settype(y, typ)
+
+    switch t.token {
+    case EQ, NE:
+        return UNTYPED_BOOL
+    case LT, LE, GT, GE:
+        if !isInteger(typ) && !isFloat(typ) && !isString(typ) {
+            c.report(t.pos, fmt.Sprintf("invalid operation: %s (operator %s not defined on %s)", exprString(t), op, kindName(typ)))
+            return VAR_INVALID
+        }
+        return UNTYPED_BOOL
+    case AND, OR:
+        return typ
+    }
+    isArith := t.token == ADD || t.token == SUB || t.token == MUL || t.token == QUO
+    if !isInteger(typ) && !(isFloat(typ) && isArith) && !(isString(typ) && t.token == ADD) {
+        c.report(t.pos, fmt.Sprintf("invalid operation: operator %s not defined on %s", op, c.operand(x)))
+        return VAR_INVALID
+    }
+    if t.token == QUO || t.token == REM {
+        // 浮点数变量除以常量0的结果为无穷大或NaN，不是错误
+        _, xconst := floatValue(x)
+        if v, ok := floatValue(y); ok && v == 0 && (xconst || isInteger(typ)) {
+            c.report(y.pos, "invalid operation: division by zero")
+            return VAR_INVALID
+        }
+    }
+    return typ
+}
+
+////////////////////////////////// 类型 ////////////////////////////
+func isUntyped(t Type) bool {
+    return t == UNTYPED_INT || t == UNTYPED_FLOAT || t == UNTYPED_STRING || t == UNTYPED_BOOL
+}
+
+func isNumeric(t Type) bool {
+    return isInteger(t) || isFloat(t)
+}
+
+func isInteger(t Type) bool {
+    switch t {
+    case VAR_INT, VAR_INT8, VAR_INT16, VAR_INT32, VAR_INT64,
+        VAR_UINT, VAR_CHAR, VAR_UINT16, VAR_UINT32, VAR_UINT64, UNTYPED_INT:
+        return true
+    }
+    return false
+}
+
+// 常量x能否用整数类型t表示
+func representable(x *big.Int, t Type) bool {
+    bits := uint(8 * typeSize(t))
+    lo, hi := new(big.Int), new(big.Int).Lsh(big.NewInt(1), bits)
+    if isSigned(t) {
+        hi.Rsh(hi, 1)
+        lo.Neg(hi)
+    }
+    return x.Cmp(lo) >= 0 && x.Cmp(hi) < 0
+}
+
+// 浮点数常量x是否超出浮点数类型T的范围
+func floatOverflows(x *big.Float, T Type) bool {
+    switch T {
+    case VAR_FLOAT32:
+        f, _ := x.Float32()
+        return math.IsInf(float64(f), 0)
+    case VAR_FLOAT:
+        f, _ := x.Float64()
+        return math.IsInf(f, 0)
+    }
+    return false
+}
+
+// 数值常量t能否用类型T表示，不能时返回原因：浮点数常量有小数部分时为truncated，
+// 超出T的范围时为overflows。t不是常量时返回空字符串
+func constRepresentable(t *ASTNode, T Type) string {
+    if isUntyped(T) {
+        return ""
+    }
+    switch {
+    case isInteger(t.vartype):
+        if x, ok := intValue(t); ok && isInteger(T) && !representable(x, T) {
+            return "overflows"
+        }
+        if f, ok := floatValue(t); ok && T == VAR_FLOAT32 && math.IsInf(float64(float32(f)), 0) {
+            return "overflows"
+        }
+    case t.vartype == UNTYPED_FLOAT:
+        x, ok := bigFloatValue(t)
+        if !ok {
+            return ""
+        }
+        switch {
+        case isInteger(T):
+            if !x.IsInt() {
+                return "truncated"
+            }
+            if n, _ := x.Int(nil); !representable(n, T) {
+                return "overflows"
+            }
+        case floatOverflows(x, T):
+            return "overflows"
+        }
+    }
+    return ""
+}
+
+// 将数值常量表达式t替换为类型为T的常量，T为整数类型时常量必须是整数。t不是常量表达式时返回false
+func fold(t *ASTNode, T Type) bool {
+    if isInteger(T) && !isFloat(t.vartype) {
+        n, ok := intValue(t)
+        if !ok {
+            return false
+        }
+        foldInt(t, n, NUM, T)
+        return true
+    }
+    x, ok := bigFloatValue(t)
+    if !ok {
+        return false
+    }
+    if isInteger(T) {
+        n, _ := x.Int(nil)
+        foldInt(t, n, NUM, T)
+        return true
+    }
+    t.nodeKind = ConstK
+    t.child = nil
+    t.vartype = T
+    t.token = FNUM
+    t.fval, _ = x.Float64()
+    if T == VAR_FLOAT32 {
+        f, _ := x.Float32()
+        t.fval = float64(f)
+    }
+    if isUntyped(T) {
+        t.litval = x.Text('g', -1)  // 未定类型的常量保留精确值
+    } else {
+        t.litval = strconv.FormatFloat(t.fval, 'g', -1, 64)
+    }
+    return true
+}
+
+// 将t替换为值为n的类型为T的整数（token为NUM）或布尔常量，intval保存n的低64位
+func foldInt(t *ASTNode, n *big.Int, token Token, T Type) {
+    t.nodeKind, t.token, t.child, t.vartype = ConstK, token, nil, T
+    t.intval = int(new(big.Int).And(n, mask64).Uint64())
+    t.litval = ""
+    if token == NUM {
+        t.litval = n.String()
+    }
+}
+
+func isBoolean(t Type) bool {
+    return t == VAR_BOOL || t == UNTYPED_BOOL
+}
+
+// 未定类型在需要确定类型时使用的默认类型
+func defaultType(t Type) Type {
+    switch t {
+    case UNTYPED_INT:
+        return VAR_INT
+    case UNTYPED_FLOAT:
+        return VAR_FLOAT
+    case UNTYPED_STRING:
+        return VAR_STRING
+    case UNTYPED_BOOL:
+        return VAR_BOOL
+    }
+    return t
+}
+
+// 类型为t的值能否赋给类型为T的变量
+func assignable(t, T Type) bool {
+    switch {
+    case t == T, t == VAR_INVALID, T == VAR_INVALID:
+        return true
+    case t == UNTYPED_INT:
+        return isNumeric(T) && T != UNTYPED_INT
+    case t == UNTYPED_FLOAT:
+        return isNumeric(T) && !isUntyped(T)
+    case t == UNTYPED_STRING:
+        return T == VAR_STRING
+    case t == UNTYPED_BOOL:
+        return T == VAR_BOOL
+    }
+    return false
+}
+
+// 类型的种类，用于运算符的错误信息
+func kindName(t Type) string {
+    if isPointer(t) {
+        return "pointer"
+    }
+    switch t {
+    case VAR_BOOL, UNTYPED_BOOL:
+        return "bool"
+    }
+    return t.String()
+}
+
+////////////////////////////////// 错误信息 ////////////////////////////
+// 操作数的描述，如 x (variable of type int)、5 (untyped int constant)
+func (c *Checker) operand(t *ASTNode) string {
+    s := exprString(t)
+    if t.vartype == UNTYPED_FLOAT {
+        if x, ok := bigFloatValue(t); ok {
+            val := x.Text('g', -1)
+            if s == val {
+                return fmt.Sprintf("%s (%s constant)", s, t.vartype)
+            }
+            return fmt.Sprintf("%s (%s constant %s)", s, t.vartype, val)
+        }
+        return fmt.Sprintf("%s (%s value)", s, t.vartype)
+    }
+    if t.vartype == UNTYPED_STRING {
+        if v, ok := stringValue(t); ok && s != strconv.Quote(v) {
+            return fmt.Sprintf("%s (%s constant %q)", s, t.vartype, v)
+        }
+        return fmt.Sprintf("%s (%s constant)", s, t.vartype)
+    }
+    if t.vartype == UNTYPED_INT || t.vartype == UNTYPED_BOOL {
+        if v, ok := intValue(t); ok {
+            val := v.String()
+            if t.vartype == UNTYPED_BOOL {
+                val = fmt.Sprint(v.Sign() != 0)
+            }
+            if s == val {
+                return fmt.Sprintf("%s (%s constant)", s, t.vartype)  // 表达式就是常量值本身
+            }
+            return fmt.Sprintf("%s (%s constant %s)", s, t.vartype, val)
+        }
+        return fmt.Sprintf("%s (%s value)", s, t.vartype)
+    }
+    if t.nodeKind == IdK {
+        return fmt.Sprintf("%s (variable of type %s)", s, t.vartype)
+    }
+    return fmt.Sprintf("%s (value of type %s)", s, t.vartype)
+}
+
+// 表达式的源代码形式
+func exprString(t *ASTNode) string {
+    switch t.nodeKind {
+    case ConstK:
+        switch t.token {
+        case TRUE, FALSE:
+            return t.token.String()
+        case FNUM:
+            return t.litval
+        case STRLIT:
+            return strconv.Quote(t.litval)
+        }
+        if t.litval != "" {
+            return t.litval  // 常量的精确值，可能超出64位
+        }
+        return fmt.Sprint(t.intval)
+    case IdK:
+        return t.litval
+    case CallK:
+        s := t.litval + "("
+        for a := t.child[0]; a != nil; a = a.sibling {
+            s += exprString(a)
+            if a.sibling != nil {
+                s += ", "
+            }
+        }
+        return s + ")"
+    case UnaryOpK:
+        return t.token.String() + exprString(t.child[0])
+    case ConvK:
+        return t.token.String() + "(" + exprString(t.child[0]) + ")"
+    case OpK:
+        return exprString(t.child[0]) + " " + t.token.String() + " " + exprString(t.child[1])
+    case IndexK:
+        return exprString(t.child[0]) + "[" + exprString(t.child[1]) + "]"
+    case SliceK:
+        s := exprString(t.child[0]) + "["
+        if t.child[1] != nil {
+            s += exprString(t.child[1])
+        }
+        s += ":"
+        if t.child[2] != nil {
+            s += exprString(t.child[2])
+        }
+        return s + "]"
+    case LenK:
+        return "len(" + exprString(t.child[0]) + ")"
+    }
+    return "?"
+}
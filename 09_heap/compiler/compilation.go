@@ -0,0 +1,64 @@
+package compiler
+
+import "strings"
+
+// Compilation 保存一次编译的全部状态（符号表、源文件、标签计数、错误），
+// 不同的Compilation互不共享数据，可以在多个goroutine中并发编译
+type Compilation struct {
+    Opts   Options
+    Files  *FileSet   // 已读入的源文件，用于将位置映射回源代码
+    Sym    *Symtable  // 符号表
+    label  int        // 下一个可用的标签id
+    diags  DiagnosticList  // 编译过程中产生的诊断信息
+}
+
+func NewCompilation(opts Options) *Compilation {
+    return &Compilation{
+        Opts:  opts,
+        Files: NewFileSet(),
+        Sym:   NewSymtable(),
+    }
+}
+
+// 返回一个新的标签id
+func (ctx *Compilation) newLabel() int {
+    ctx.label++
+    return ctx.label-1
+}
+
+// 记录一条诊断信息
+func (ctx *Compilation) addDiagnostic(d *Diagnostic) {
+    ctx.diags = append(ctx.diags, d)
+}
+
+// Diagnostics 返回编译过程中产生的全部诊断信息
+func (ctx *Compilation) Diagnostics() DiagnosticList {
+    return ctx.diags
+}
+
+// 错误级别的诊断信息个数
+func (ctx *Compilation) errorCount() int {
+    n := 0
+    for _, d := range ctx.diags {
+        if d.Severity == SevError {
+            n++
+        }
+    }
+    return n
+}
+
+// Err 存在错误级别的诊断信息时按位置排序后返回它们，否则返回nil
+func (ctx *Compilation) Err() error {
+    for _, d := range ctx.diags {
+        if d.Severity == SevError {
+            ctx.diags.sort()
+            return ctx.diags
+        }
+    }
+    return nil
+}
+
+// ParseString 解析内存中的源代码src，name为用于错误信息的文件名
+func (ctx *Compilation) ParseString(name, src string) (*ASTNode, error) {
+    return NewParser(ctx, name, strings.NewReader(src)).Parse()
+}
@@ -0,0 +1,70 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 用于并发编译的源程序，各自的全局变量、函数和标签互不相同
+var concurrentSources = []string{
+	"var g int = 3\n\nfunc main() {\n\tprint g * 2\n}\n",
+	"func fib(n int) int {\n\tif n < 2 {\n\t\treturn n\n\t}\n\treturn fib(n-1) + fib(n-2)\n}\n\nfunc main() {\n\tprint fib(10)\n}\n",
+	"func main() {\n\tvar s string = \"ab\"\n\tfor i := 0; i < 3; i++ {\n\t\ts = s + \"c\"\n\t}\n\tprint s\n}\n",
+	"func half(x float64) (float64, int) {\n\treturn x / 2, 1\n}\n\nfunc main() {\n\tf, n := half(2.5)\n\tprint f\n\tprint n\n}\n",
+}
+
+// compileString 在新的Compilation中编译src，返回生成的汇编代码
+func compileString(name, src string) (string, error) {
+	ctx := NewCompilation(Options{})
+	tree, err := ctx.ParseString(name, src)
+	if err != nil {
+		return "", err
+	}
+	if err := NewChecker(ctx, tree).Check(); err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := NewCgen(ctx, tree, &out).GenAST(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// TestConcurrentCompilations 在多个goroutine中同时编译不同的程序，
+// 结果必须与逐个编译时相同。用-race运行时还能发现共享的状态
+func TestConcurrentCompilations(t *testing.T) {
+	want := make([]string, len(concurrentSources))
+	for i, src := range concurrentSources {
+		asm, err := compileString(fmt.Sprintf("prog%d.mygo", i), src)
+		if err != nil {
+			t.Fatalf("prog%d.mygo: %v", i, err)
+		}
+		want[i] = asm
+	}
+
+	const rounds = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, rounds*len(concurrentSources))
+	for r := 0; r < rounds; r++ {
+		for i, src := range concurrentSources {
+			wg.Add(1)
+			go func(i int, src string) {
+				defer wg.Done()
+				name := fmt.Sprintf("prog%d.mygo", i)
+				asm, err := compileString(name, src)
+				if err != nil {
+					errs <- fmt.Errorf("%s: %v", name, err)
+				} else if asm != want[i] {
+					errs <- fmt.Errorf("%s: output differs from a sequential compilation", name)
+				}
+			}(i, src)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
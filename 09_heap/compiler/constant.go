@@ -0,0 +1,327 @@
+package compiler
+
+import (
+    "math"
+    "math/big"
+)
+
+// 整数常量的移位次数的上限，与Go相同
+const shiftBound = 1023 - 1 + 52
+
+// 64位的掩码，用于取整数常量的低64位
+var mask64 = new(big.Int).SetUint64(math.MaxUint64)
+
+// constValue 计算整数（或布尔）常量表达式t的值，只保留低64位，即常量在寄存器中的二进制表示。
+// t不是这样的常量表达式时ok为false
+func constValue(t *ASTNode) (v int, ok bool) {
+    x, ok := intValue(t)
+    if !ok {
+        return 0, false
+    }
+    return int(new(big.Int).And(x, mask64).Uint64()), true
+}
+
+// intValue 计算整数（或布尔）常量表达式t的精确值。与Go相同，未定类型的整数常量没有范围限制，
+// 常量能否用具体的类型表示由Checker检查。t不是这样的常量表达式时ok为false
+func intValue(t *ASTNode) (x *big.Int, ok bool) {
+    switch t.nodeKind {
+    case ConstK:
+        switch t.token {
+        case FNUM, STRLIT:
+            return nil, false
+        case TRUE, FALSE:
+            return big.NewInt(int64(t.intval)), true
+        }
+        if t.litval != "" {
+            return new(big.Int).SetString(t.litval, 10)
+        }
+        return big.NewInt(int64(t.intval)), true
+    case LenK:
+        if s, ok := stringValue(t.child[0]); ok {
+            return big.NewInt(int64(len(s))), true  // 字符串常量的长度是常量
+        }
+    case ConvK:
+        if isFloat(basictypes[t.token]) {
+            return nil, false
+        }
+        if isFloat(t.child[0].vartype) {
+            // 浮点数常量转换为整数时不能有小数部分，由Checker检查
+            f, ok := bigFloatValue(t.child[0])
+            if !ok || !f.IsInt() {
+                return nil, false
+            }
+            x, _ := f.Int(nil)
+            return x, true
+        }
+        return intValue(t.child[0])  // 常量必须能用目标类型表示，由Checker检查
+    case UnaryOpK:
+        if t.token == MUL || t.token == AMPER {
+            return nil, false
+        }
+        x, ok := intValue(t.child[0])
+        if !ok {
+            return nil, false
+        }
+        z := new(big.Int)
+        switch t.token {
+        case ADD:
+            return x, true
+        case SUB:
+            return z.Neg(x), true
+        case XOR:
+            if T := t.child[0].vartype; isInteger(T) && !isUntyped(T) && !isSigned(T) {
+                // 无符号数按位取反时只取反类型的位数
+                m := new(big.Int).Lsh(big.NewInt(1), uint(8*typeSize(T)))
+                return z.Xor(x, m.Sub(m, big.NewInt(1))), true
+            }
+            return z.Not(x), true
+        case NOT:
+            return big.NewInt(int64(boolValue(x.Sign() == 0))), true
+        }
+    case OpK:
+        if x, ok := stringValue(t.child[0]); ok {
+            y, ok := stringValue(t.child[1])
+            if !ok {
+                return nil, false
+            }
+            v, ok := compareStrings(x, y, t.token)
+            return big.NewInt(int64(v)), ok
+        }
+        x, ok := intValue(t.child[0])
+        if !ok {
+            return nil, false
+        }
+        y, ok := intValue(t.child[1])
+        if !ok {
+            return nil, false
+        }
+        z := new(big.Int)
+        switch t.token {
+        case ADD:
+            return z.Add(x, y), true
+        case SUB:
+            return z.Sub(x, y), true
+        case MUL:
+            return z.Mul(x, y), true
+        case QUO, REM:
+            if y.Sign() == 0 {
+                return nil, false
+            }
+            if t.token == QUO {
+                return z.Quo(x, y), true  // 与Go相同，向零取整
+            }
+            return z.Rem(x, y), true
+        case AMPER:
+            return z.And(x, y), true
+        case PIPE:
+            return z.Or(x, y), true
+        case XOR:
+            return z.Xor(x, y), true
+        case ANDNOT:
+            return z.AndNot(x, y), true
+        case SHL, SHR:
+            if y.Sign() < 0 || y.Cmp(big.NewInt(shiftBound)) > 0 {
+                return nil, false  // 非法的移位次数由Checker报告
+            }
+            if t.token == SHL {
+                return z.Lsh(x, uint(y.Int64())), true
+            }
+            return z.Rsh(x, uint(y.Int64())), true
+        case EQ:
+            return bigBool(x.Cmp(y) == 0), true
+        case NE:
+            return bigBool(x.Cmp(y) != 0), true
+        case LT:
+            return bigBool(x.Cmp(y) < 0), true
+        case LE:
+            return bigBool(x.Cmp(y) <= 0), true
+        case GT:
+            return bigBool(x.Cmp(y) > 0), true
+        case GE:
+            return bigBool(x.Cmp(y) >= 0), true
+        case AND:
+            return bigBool(x.Sign() != 0 && y.Sign() != 0), true
+        case OR:
+            return bigBool(x.Sign() != 0 || y.Sign() != 0), true
+        }
+    }
+    return nil, false
+}
+
+// 比较结果的常量表示
+func bigBool(b bool) *big.Int {
+    return big.NewInt(int64(boolValue(b)))
+}
+
+// isConst 报告t是否是由常量和运算符组成的常量表达式，不需要类型信息
+func isConst(t *ASTNode) bool {
+    switch t.nodeKind {
+    case ConstK:
+        return true
+    case ConvK, LenK:
+        return isConst(t.child[0])
+    case UnaryOpK:
+        return t.token != MUL && t.token != AMPER && isConst(t.child[0])
+    case OpK:
+        return isConst(t.child[0]) && isConst(t.child[1])
+    }
+    return false
+}
+
+// stringValue 计算字符串常量表达式t的值，t不是字符串常量表达式时ok为false
+func stringValue(t *ASTNode) (s string, ok bool) {
+    switch t.nodeKind {
+    case ConstK:
+        return t.litval, t.token == STRLIT
+    case ConvK:
+        if basictypes[t.token] == VAR_STRING {
+            return stringValue(t.child[0])
+        }
+    case OpK:
+        if t.token != ADD {
+            return "", false
+        }
+        x, ok := stringValue(t.child[0])
+        if !ok {
+            return "", false
+        }
+        y, ok := stringValue(t.child[1])
+        return x + y, ok
+    }
+    return "", false
+}
+
+// 字符串常量的比较，结果为1或0
+func compareStrings(x, y string, how Token) (v int, ok bool) {
+    switch how {
+    case EQ:
+        return boolValue(x == y), true
+    case NE:
+        return boolValue(x != y), true
+    case LT:
+        return boolValue(x < y), true
+    case LE:
+        return boolValue(x <= y), true
+    case GT:
+        return boolValue(x > y), true
+    case GE:
+        return boolValue(x >= y), true
+    }
+    return 0, false
+}
+
+// 比较结果的整数表示
+func boolValue(b bool) int {
+    if b {
+        return 1
+    }
+    return 0
+}
+
+// 浮点数常量的精度（位数）。与Go相同，未定类型的常量按高精度计算，
+// 在转换为具体的浮点数类型时才舍入
+const floatPrec = 512
+
+// floatValue 计算数值常量表达式t的值并舍入为float64，float32类型的表达式舍入为float32。
+// t不是数值常量表达式时ok为false
+func floatValue(t *ASTNode) (v float64, ok bool) {
+    x, ok := bigFloatValue(t)
+    if !ok {
+        return 0, false
+    }
+    if t.vartype == VAR_FLOAT32 {
+        f, _ := x.Float32()
+        return float64(f), true
+    }
+    v, _ = x.Float64()
+    return v, true
+}
+
+// bigFloatValue 计算数值常量表达式t的精确值。t的类型为浮点数时按浮点数计算，
+// 具体的浮点数类型的运算结果舍入为该类型；否则按整数计算
+func bigFloatValue(t *ASTNode) (x *big.Float, ok bool) {
+    if t.nodeKind == ConstK && t.token == FNUM {
+        x, _, err := big.ParseFloat(t.litval, 10, floatPrec, big.ToNearestEven)
+        return x, err == nil
+    }
+    if !isFloat(t.vartype) {
+        if t.nodeKind == ConstK && (t.token == TRUE || t.token == FALSE) {
+            return nil, false
+        }
+        n, ok := intValue(t)
+        if !ok {
+            return nil, false
+        }
+        return new(big.Float).SetPrec(floatPrec).SetInt(n), true
+    }
+    switch t.nodeKind {
+    case ConstK:
+        n, ok := intValue(t)
+        if !ok {
+            return nil, false
+        }
+        return new(big.Float).SetPrec(floatPrec).SetInt(n), true
+    case ConvK:
+        x, ok := bigFloatValue(t.child[0])
+        if !ok {
+            return nil, false
+        }
+        return roundFloat(x, t.vartype), true
+    case UnaryOpK:
+        x, ok := bigFloatValue(t.child[0])
+        if !ok {
+            return nil, false
+        }
+        switch t.token {
+        case ADD:
+            return x, true
+        case SUB:
+            return new(big.Float).SetPrec(floatPrec).Neg(x), true
+        }
+    case OpK:
+        x, ok := bigFloatValue(t.child[0])
+        if !ok {
+            return nil, false
+        }
+        y, ok := bigFloatValue(t.child[1])
+        if !ok {
+            return nil, false
+        }
+        z := new(big.Float).SetPrec(floatPrec)
+        switch t.token {
+        case ADD:
+            z.Add(x, y)
+        case SUB:
+            z.Sub(x, y)
+        case MUL:
+            z.Mul(x, y)
+        case QUO:
+            if y.Sign() == 0 {
+                return nil, false
+            }
+            z.Quo(x, y)
+        default:
+            return nil, false
+        }
+        return roundFloat(z, t.vartype), true
+    }
+    return nil, false
+}
+
+// 将x舍入为浮点数类型T的值。T为未定类型，或者x超出T的范围时不舍入，溢出由Checker报告
+func roundFloat(x *big.Float, T Type) *big.Float {
+    var f float64
+    switch T {
+    case VAR_FLOAT32:
+        f32, _ := x.Float32()
+        f = float64(f32)
+    case VAR_FLOAT:
+        f, _ = x.Float64()
+    default:
+        return x
+    }
+    if math.IsInf(f, 0) {
+        return x
+    }
+    return new(big.Float).SetPrec(floatPrec).SetFloat64(f)
+}
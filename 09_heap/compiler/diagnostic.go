@@ -0,0 +1,75 @@
+package compiler
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// 诊断信息的严重程度
+type Severity int
+const (
+    SevError Severity = iota
+    SevWarning
+)
+
+var severities = [...]string{
+    "error",
+    "warning",
+}
+
+func (s Severity) String() string {
+    return severities[s]
+}
+
+// Diagnostic 编译过程中产生的一条诊断信息
+type Diagnostic struct {
+    Severity Severity
+    Pos      Pos       // 出错位置，可能只有文件名
+    Msg      string
+    Notes    []string  // 附加说明
+}
+
+// Error 按 file:line:col: message 的格式返回诊断信息
+func (d *Diagnostic) Error() string {
+    var b strings.Builder
+    if d.Pos.File != "" || d.Pos.IsValid() {
+        b.WriteString(d.Pos.String())
+        b.WriteString(": ")
+    }
+    if d.Severity != SevError {
+        fmt.Fprintf(&b, "%s: ", d.Severity)
+    }
+    b.WriteString(d.Msg)
+    return b.String()
+}
+
+// DiagnosticList 一组诊断信息，作为Parse和GenAST的错误返回
+type DiagnosticList []*Diagnostic
+
+func (l DiagnosticList) Error() string {
+    switch len(l) {
+    case 0:
+        return "no errors"
+    case 1:
+        return l[0].Error()
+    }
+    return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// 按源代码中的位置排序，同一位置保持记录的顺序
+func (l DiagnosticList) sort() {
+    sort.SliceStable(l, func(i, j int) bool {
+        a, b := l[i].Pos, l[j].Pos
+        if a.File != b.File {
+            return a.File < b.File
+        }
+        return a.Offset < b.Offset
+    })
+}
+
+// 编译出错时的panic值，由Parse和GenAST恢复
+type bailout struct{}
+
+// 错误数超过上限时的panic值，终止整个语法分析
+type tooManyErrors struct{}
@@ -1,11 +0,0 @@
-package compiler
-
-var GLineno int = 0
-
-var GTraceScan = false
-var GTraceParse = true
-
-
-
-
-
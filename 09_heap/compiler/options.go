@@ -0,0 +1,31 @@
+package compiler
+
+import (
+    "io"
+    "os"
+)
+
+// 编译选项，零值表示静默编译
+type Options struct {
+    TraceTokens bool       // 打印扫描得到的每个记号
+    DumpAST     bool       // 打印语法树
+    Trace       io.Writer  // 跟踪信息和语法树的输出位置，为nil时输出到标准错误
+    MaxErrors   int        // 最多报告的错误数，0表示使用默认值
+}
+
+// 默认最多报告的错误数
+const defaultMaxErrors = 10
+
+func (o *Options) traceWriter() io.Writer {
+    if o.Trace != nil {
+        return o.Trace
+    }
+    return os.Stderr
+}
+
+func (o *Options) maxErrors() int {
+    if o.MaxErrors > 0 {
+        return o.MaxErrors
+    }
+    return defaultMaxErrors
+}
@@ -1,118 +1,419 @@
 /*
 program -> stmt-sequence
 stmt-sequence -> statement{;statement]
-statement -> if-stmt|for-stmt|assign-stmt|print-stmt|return-stmt|var-declare|func-declare
+statement -> if-stmt|for-stmt|simple-stmt|print-stmt|return-stmt|var-declare|func-declare
+    |break-stmt|continue-stmt|goto-stmt|labeled-stmt|block
+block -> {stmt-sequence}
+simple-stmt -> assign-stmt|define-stmt|incdec-stmt|call-stmt
 
-var-declare -> var identifier var-type
-var-type -> int|float|string
+var-declare -> var identifier{,identifier} var-type [= exp-list] | var identifier{,identifier} = exp-list
+var-type -> [*]type-name
+type-name -> int|int8|int16|int32|int64|uint|uint8|uint16|uint32|uint64|byte|char|bool|float32|float64|string
 
-func-declare -> func identifier(identifier var-type) var-type {
+func-declare -> func identifier([param-list]) [result] {
     stmt-sequence
 }
+param-list -> identifier{,identifier} var-type{,identifier{,identifier} var-type}
+result -> var-type | (var-type{,var-type}) | (param-list)
 
-if-stmt -> if exp [stmt-sequence] [else stmt-sequence]
-for-stmt -> for assign-stmt;exp;exp [stmt-sequence]
-assign-stmt -> identifier = exp
+if-stmt -> if [simple-stmt;] exp block [else (if-stmt|block)]
+for-stmt -> for [simple-stmt];[exp];[simple-stmt] block
+    | for exp block | for block
+assign-stmt -> lhs{,lhs} = exp-list
+define-stmt -> identifier{,identifier} := exp-list
+incdec-stmt -> lhs ++ | lhs --
+lhs -> identifier | *identifier
+call-stmt -> identifier([exp-list])
 print-stmo -> print exp
-returtn-stmt -> return exp
+returtn-stmt -> return [exp-list]
+break-stmt -> break [identifier]
+continue-stmt -> continue [identifier]
+goto-stmt -> goto identifier
+labeled-stmt -> identifier : [statement]
+exp-list -> exp{,exp}
 
-exp -> simple-exp[comparison-op simple-exp]
-comparison-op -> < | =
+exp -> and-exp{|| and-exp}
+and-exp -> comparison-exp{&& comparison-exp}
+comparison-exp -> simple-exp{comparison-op simple-exp}
+comparison-op -> == | != | < | <= | > | >=
 simple-exp -> term{addop term}
-addop -> + | -
-term -> factor{mulop factor}
-mulop -> * | /
-factor -> (exp) | number | identifier | identifier(factor)
+addop -> + | - | '|' | ^
+term -> unary-exp{mulop unary-exp}
+mulop -> * | / | % | << | >> | & | &^
+unary-exp -> unary-op unary-exp | primary-exp
+unary-op -> + | - | ! | ^
+primary-exp -> factor{[exp] | [[exp]:[exp]]}
+factor -> (exp) | number | string | true | false | type-name(exp) | len(exp) | identifier | identifier([exp-list])
+    | *identifier | &identifier
 */
 
 package compiler
 
 import (
     "fmt"
-    "os"
+    "io"
+    "sort"
     "strconv"
 )
 
 type Parser struct {
-    s *Scanner
+    scanners []*Scanner   // 包中每个源文件的扫描器
+    files [][]tokenInfo   // 每个源文件扫描得到的全部token，各以ENDFILE结尾
+    toks []tokenInfo      // 正在分析的源文件的token
+    tokpos int        // 下一个token在toks中的位置
     curToken Token    // 当前token
     curLit string     // 当前lit
-    cacheToken Token  // 向前查看一个token
-    cacheLit string
+    curPos Pos        // 当前token的位置
 
     currentFunc int    // 当前所处函数的插槽id
     currentOffset int  // local变量当前偏移量
+    results *ASTNode   // 当前函数的命名返回值
+
+    // 当前函数中的标签与跳转，在函数结束时检查
+    loops  []string               // 外层循环的标签，无标签的循环为""
+    labels map[string]*labelInfo  // 已定义的标签
+    gotos  []gotoInfo             // goto语句
+
+    undefs *[]undefinedName  // 非nil时暂存未定义的名字，见simple_stmt
+
+    // 收集包级声明时为true，见collect
+    collecting bool
+    collected map[int]bool  // 已收集但尚未正式声明的全局符号
+
+    ctx *Compilation
+    sym *Symtable
+}
+
+// 扫描得到的token
+type tokenInfo struct {
+    token Token
+    lit   string
+    pos   Pos
+}
+
+// 标签的定义
+type labelInfo struct {
+    pos    Pos
+    scopes []int  // 标签所在的作用域路径
+    used   bool
+}
+
+// 未定义的名字及其位置
+type undefinedName struct {
+    pos  Pos
+    name string
 }
 
-func NewParser(file *os.File) *Parser {
-    s := NewScanner(file)
+// goto语句及其所在的作用域路径
+type gotoInfo struct {
+    node   *ASTNode
+    scopes []int
+}
+
+// NewParser 创建一个从r读取源代码的语法分析器，name为源文件名。包中的其他源文件由AddFile添加
+func NewParser(ctx *Compilation, name string, r io.Reader) *Parser {
     p := Parser{
-        s: s,
-        cacheToken: -1,
-        cacheLit: "",
+        ctx: ctx,
+        sym: ctx.Sym,
         currentFunc: -1,
         currentOffset: 0,
+        collected: make(map[int]bool),
     }
-    p.curToken, p.curLit = p.s.GetToken()
+    p.AddFile(name, r)
     return &p
 }
 
+// AddFile 添加同一个包中的源文件。包中的全部源文件共用包级作用域，
+// 一个文件中可以使用其他文件声明的函数和全局变量
+func (p *Parser) AddFile(name string, r io.Reader) {
+    p.scanners = append(p.scanners, NewScanner(p.ctx, name, r))
+}
+
+// 扫描每个源文件的全部token。收集声明和语法分析都在这些token上进行
+func (p *Parser) scan() {
+    for _, s := range p.scanners {
+        var toks []tokenInfo
+        for {
+            token, lit, pos := s.GetToken()
+            toks = append(toks, tokenInfo{token, lit, pos})
+            if token == ENDFILE {
+                break
+            }
+        }
+        p.files = append(p.files, toks)
+    }
+}
+
+// 开始分析第i个源文件
+func (p *Parser) open(i int) {
+    p.toks = p.files[i]
+    p.seek(0)
+}
+
+// 从toks中第i个token开始分析
+func (p *Parser) seek(i int) {
+    p.tokpos = i
+    p.next()
+}
+
+// 读取下一个token作为当前token，到达ENDFILE后停留在ENDFILE
+func (p *Parser) next() {
+    t := p.toks[p.tokpos]
+    p.curToken, p.curLit, p.curPos = t.token, t.lit, t.pos
+    if p.tokpos < len(p.toks)-1 {
+        p.tokpos++
+    }
+}
+
+// 创建一个位于当前token处的语法树节点
+func (p *Parser) newNode(nodeKind NodeKind) *ASTNode {
+    t := NewASTNode(nodeKind)
+    t.pos = p.curPos
+    return t
+}
+
+// report 在当前token处记录一个错误，之后继续语法分析。
+// 同一行只保留第一个错误，错误数超过上限时终止整个语法分析
+func (p *Parser) report(msg string) {
+    p.reportAt(p.curPos, msg)
+}
+
+// reportAt 在位置pos处记录一个错误，notes为附加说明
+func (p *Parser) reportAt(pos Pos, msg string, notes ...string) {
+    if p.collecting {
+        return  // 正式分析时会再次发现同样的错误
+    }
+    if n := len(p.ctx.diags); n > 0 {
+        last := p.ctx.diags[n-1].Pos
+        if last.File == pos.File && last.Line == pos.Line {
+            return
+        }
+    }
+    if p.ctx.errorCount() >= p.ctx.Opts.maxErrors() {
+        p.ctx.addDiagnostic(&Diagnostic{
+            Severity: SevError,
+            Pos:      pos,
+            Msg:      "too many errors",
+        })
+        panic(tooManyErrors{})
+    }
+    p.ctx.addDiagnostic(&Diagnostic{
+        Severity: SevError,
+        Pos:      pos,
+        Msg:      msg,
+        Notes:    notes,
+    })
+}
+
+// error 在当前token处报告错误，并放弃当前语句
 func (p *Parser) error(msg string) {
-    fmt.Printf("Parse Error>> Line %d: %s\n, Position %d: %v\n", GLineno, p.s.linebuf, p.s.linepos, p.s.linebuf[p.s.linepos])
-    panic(msg)
+    p.report(msg)
+    panic(bailout{})
+}
+
+// 报告语法错误：当前token不是期望的内容
+func (p *Parser) errorExpected(what string) {
+    found := p.curToken.String()
+    switch p.curToken {
+    case ID, NUM, FNUM, STRLIT:
+        found += " " + p.curLit
+    }
+    p.error(fmt.Sprintf("syntax error: unexpected %s, expected %s", found, what))
 }
 
 // 匹配消耗一个token
 func (p *Parser) match(token Token) {
     if p.curToken == token {
-        if p.cacheToken != -1 {
-            p.curToken, p.curLit = p.cacheToken, p.cacheLit
-            p.cacheToken = -1
-            p.cacheLit = ""
-        } else {
-            p.curToken, p.curLit = p.s.GetToken()
-        }
+        p.next()
     } else {
-        p.error("Error: token not match")
+        p.errorExpected(token.String())
     }
 }
 
 // 往后查看一个token
 func (p *Parser) prev() Token {
-    if p.cacheToken != -1 {
-        return p.cacheToken
-    } else {
-        p.cacheToken, p.cacheLit = p.s.GetToken()
-        return p.cacheToken
-    }
+    return p.toks[p.tokpos].token
 }
 
-// 语法树解析
-func (p *Parser) Parse() *ASTNode {
-    var t *ASTNode
+// 语法树解析，出错时返回收集到的全部错误（DiagnosticList）
+func (p *Parser) Parse() (t *ASTNode, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(tooManyErrors); !ok {
+                panic(r)
+            }
+            t, err = nil, p.ctx.Err()
+        }
+    }()
 
-    t = p.stmt_sequence()
-    if GTraceParse{
-        t.printTree(0)
+    p.scan()
+    p.collect()
+    for i := range p.files {
+        p.open(i)
+        t = appendStmt(t, p.stmt_sequence())
+        for p.curToken != ENDFILE {
+            // 顶层多余的右大括号
+            p.report(fmt.Sprintf("syntax error: unexpected %s, expected declaration", p.curToken))
+            p.match(p.curToken)
+            t = appendStmt(t, p.stmt_sequence())
+        }
+    }
+    if err = p.ctx.Err(); err != nil {
+        return nil, err
+    }
+    if p.ctx.Opts.DumpAST && t != nil {
+        t.printTree(p.ctx.Opts.traceWriter(), 0)
     }
 
+    return t, nil
+}
+
+// 将语句序列q追加到t的末尾
+func appendStmt(t, q *ASTNode) *ASTNode {
+    if t == nil {
+        return q
+    }
+    n := t
+    for n.sibling != nil {
+        n = n.sibling
+    }
+    n.sibling = q
     return t
 }
 
+// 解析一条语句。出错时跳过到下一个同步点并返回nil，以便继续报告后续的错误
+func (p *Parser) stmt_recover() (t *ASTNode) {
+    pos := p.curPos
+    nscopes, nloops, fn := len(p.sym.scopes), len(p.loops), p.currentFunc
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(bailout); !ok {
+                panic(r)
+            }
+            // 关闭放弃的语句中打开的作用域和循环，放弃的函数声明不再是当前函数
+            p.sym.scopes, p.loops, p.currentFunc = p.sym.scopes[:nscopes], p.loops[:nloops], fn
+            if p.curPos == pos && p.curToken != ENDFILE && p.curToken != LBRACE {
+                p.match(p.curToken)  // 保证至少前进一个token，左大括号由synchronize整体跳过
+            }
+            p.synchronize()
+            t = nil
+        }
+    }()
+    return p.statement()
+}
+
+// 语句的起始关键字，出错后在这些位置恢复语法分析
+var stmtStart = map[Token]bool{
+    IF:       true,
+    FOR:      true,
+    BREAK:    true,
+    CONTINUE: true,
+    GOTO:     true,
+    VAR:      true,
+    FUNC:     true,
+    PRINT:    true,
+    RETURN:   true,
+}
+
+// synchronize 跳过token直到分号（消耗）、右大括号、语句起始关键字或新的一行。
+// 遇到的左大括号连同其匹配的右大括号整体跳过
+func (p *Parser) synchronize() {
+    depth := 0
+    line := p.curPos.Line
+    for p.curToken != ENDFILE {
+        if depth == 0 && p.curPos.Line > line {
+            return  // 语句通常以换行结束
+        }
+        switch {
+        case p.curToken == LBRACE:
+            depth++
+        case p.curToken == RBRACE:
+            if depth == 0 {
+                return
+            }
+            depth--
+        case depth == 0 && p.curToken == SEMI:
+            p.match(SEMI)
+            return
+        case depth == 0 && stmtStart[p.curToken]:
+            return
+        }
+        p.match(p.curToken)
+    }
+}
+
+// 收集包级声明：在正式分析之前登记包中全部源文件的全局变量和函数的签名，
+// 使它们可以在声明之前使用，函数之间可以相互递归调用。
+// 收集时跳过函数体，也不报告错误
+func (p *Parser) collect() {
+    p.collecting = true
+    for i := range p.files {
+        p.open(i)
+        depth := 0
+        for p.curToken != ENDFILE {
+            switch p.curToken {
+            case LBRACE:
+                depth++
+            case RBRACE:
+                if depth > 0 {
+                    depth--
+                }
+            case FUNC, VAR:
+                if depth == 0 {
+                    p.collect_decl()
+                    continue
+                }
+            }
+            p.next()
+        }
+    }
+    p.collecting = false
+}
+
+// 收集一个包级的函数或变量声明，出错时放弃这个声明
+func (p *Parser) collect_decl() {
+    pos := p.curPos
+    nscopes := len(p.sym.scopes)
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(bailout); !ok {
+                panic(r)
+            }
+            p.sym.scopes = p.sym.scopes[:nscopes]
+            if p.curPos == pos {
+                p.next()  // 保证至少前进一个token
+            }
+        }
+        p.currentFunc = -1
+    }()
+    if p.curToken == FUNC {
+        p.func_declaration()
+    } else {
+        p.var_declaration()
+    }
+}
+
 // 递归：语句序列
 func (p *Parser) stmt_sequence() *ASTNode {
-    t := p.statement()  // t指向第一个语句
-    var n *ASTNode = t
+    var t, n *ASTNode  // t指向第一个语句，n指向最后一个语句
 
     for p.curToken != ENDFILE {
         if p.curToken == SEMI {
             p.match(SEMI)
+            continue
         }
         if p.curToken == RBRACE {
             break  // 匹配到右大括号意味着语句序列的结束
         }
-        q := p.statement()
-        n.sibling = q
+        q := p.stmt_recover()
+        if q == nil {
+            continue
+        }
+        if t == nil {
+            t = q
+        } else {
+            n.sibling = q
+        }
         n = q
     }
     return t
@@ -121,260 +422,1040 @@ func (p *Parser) stmt_sequence() *ASTNode {
 // 递归：语句类型
 func (p *Parser) statement() *ASTNode {
     var t *ASTNode
+    if p.currentFunc == -1 && p.curToken != VAR && p.curToken != FUNC {
+        p.error("syntax error: non-declaration statement outside function body")  // 包级只能声明变量和函数
+    }
     switch p.curToken {
     case PRINT:
         t = p.print_stmt()
     case VAR:
         t = p.var_declaration()
     case FUNC:
+        if p.currentFunc != -1 {
+            p.error("function declaration not allowed inside function body")
+        }
         t = p.func_declaration()
-    case ID, MUL:
-        t = p.assign_stmt()
+    case ID:
+        if p.prev() == COLON {
+            t = p.labeled_stmt()
+        } else {
+            t = p.simple_stmt()
+            p.checkstmt(t)
+        }
+    case MUL:
+        t = p.simple_stmt()
+        p.checkstmt(t)
     case IF:
         t = p.if_stmt()
+    case LBRACE:
+        t = p.newNode(BlockK)
+        t.child[0] = p.block()
     case FOR:
-        t = p.for_stmt()
+        t = p.for_stmt("")
     case RETURN:
         t = p.return_stmt()
+    case BREAK, CONTINUE:
+        t = p.branch_stmt()
+    case GOTO:
+        t = p.goto_stmt()
     default:
-        return nil
+        p.errorExpected("statement")
     }
     return t
 }
 
-// 添加变量到符号表
-func (p *Parser) addglob(token Token, name string, isPointer bool) int {
-    var i int  // 变量的插槽位置
-    switch token {
-    case CHAR:
-        if isPointer {
-            i = Gsym.Addglob(name, VAR_POINTER_CHAR)
-        } else {
-            i = Gsym.Addglob(name, VAR_CHAR)
+// 添加变量n到符号表
+func (p *Parser) addglob(token Token, n *ASTNode, isPointer bool) int {
+    return p.declareglob(n.litval, n.pos, p.vartype(token, isPointer))
+}
+
+func (p *Parser) addlocal(token Token, n *ASTNode, isPointer bool) int {
+    return p.declarelocal(n.litval, n.pos, p.vartype(token, isPointer))
+}
+
+// 添加在pos处声明的类型为vartype的全局符号，同名的全局符号只能声明一次。
+// 正式分析时，声明使用collect登记的同名符号
+func (p *Parser) declareglob(name string, pos Pos, vartype Type) int {
+    if i := p.sym.Findglob(name); i != -1 {
+        if p.collecting {
+            return i  // 重复的声明在正式分析时报告
         }
-    case INT:
-        if isPointer {
-            i = Gsym.Addglob(name, VAR_POINTER_INT)
-        } else {
-            i = Gsym.Addglob(name, VAR_INT)
+        if !p.collected[i] {
+            p.redeclared(name, pos, i)
+            return i
         }
-    default:
-        p.error("Parse error: unspported vartype")
+        delete(p.collected, i)
+        p.sym.symbles[i].Vartype = vartype
+        return i
+    }
+    i := p.sym.Addglob(name, vartype)
+    p.sym.symbles[i].Pos = pos
+    if p.collecting {
+        p.collected[i] = true
     }
     return i
 }
 
-func (p *Parser) addlocal(token Token, name string, isPointer bool) int {
-    var i int  // 变量的插槽位置
-    switch token {
-    case CHAR:
-        if isPointer {
-            i = Gsym.Addlocal(name, VAR_POINTER_CHAR)
-            p.currentOffset += 8
-            Gsym.SetOffset(i, -p.currentOffset)
-            Gsym.SetFuncOffset(p.currentFunc, 8)
-        } else {
-            i = Gsym.Addlocal(name, VAR_CHAR)
-            p.currentOffset += 4  // 为了对齐
-            Gsym.SetOffset(i, -p.currentOffset)
-            Gsym.SetFuncOffset(p.currentFunc, 4)
-        }
-    case INT:
-        if isPointer {
-            i = Gsym.Addlocal(name, VAR_POINTER_INT)
-            p.currentOffset += 8
-            Gsym.SetOffset(i, -p.currentOffset)
-            Gsym.SetFuncOffset(p.currentFunc, 8)
-        } else {
-            i = Gsym.Addlocal(name, VAR_INT)
-            p.currentOffset += 8
-            Gsym.SetOffset(i, -p.currentOffset)
-            Gsym.SetFuncOffset(p.currentFunc, 8)
-        }
-    default:
-        p.error("Parse error: unspported vartype")
+// 添加在pos处声明的类型为vartype的局部变量，在栈帧中为其分配空间。
+// 内层作用域的变量可以遮蔽外层的同名变量，同一作用域中不能重复声明
+func (p *Parser) declarelocal(name string, pos Pos, vartype Type) int {
+    if i := p.sym.Findscope(name); i != -1 {
+        p.redeclared(name, pos, i)
+    }
+    i := p.sym.Addlocal(name, vartype)
+    p.sym.symbles[i].Pos = pos
+    size := 8
+    if typeSize(vartype) < 8 {
+        size = 4  // 为了对齐
     }
+    p.currentOffset += size
+    p.sym.SetOffset(i, -p.currentOffset)
+    p.sym.SetFuncOffset(p.currentFunc, size)
     if p.currentFunc != -1 {
-        Gsym.SetBelongFunc(i, p.currentFunc)  // 设置变量作用域
+        p.sym.SetBelongFunc(i, p.currentFunc)  // 设置变量作用域
     }
     return i
 }
 
-//func (p *Parser) addparam(token Token, name string, isPointer bool) int {
-//    var i int  // 变量的插槽位置
-//    switch token {
-//    case CHAR:
-//        if isPointer {
-//            i = Gsym.Addlocal(name, VAR_POINTER_CHAR)
-//            Gsym.SetOffset(i, 16)
-//        } else {
-//            i = Gsym.Addlocal(name, VAR_CHAR)
-//            Gsym.SetOffset(i, 16)
-//        }
-//    case INT:
-//        if isPointer {
-//            i = Gsym.Addlocal(name, VAR_POINTER_INT)
-//            Gsym.SetOffset(i, 16)
-//        } else {
-//            i = Gsym.Addlocal(name, VAR_INT)
-//            Gsym.SetOffset(i, 16)
-//        }
-//    default:
-//        p.error("Parse error: unspported vartype")
-//    }
-//    if p.currentFunc != -1 {
-//        Gsym.SetBelongFunc(i, p.currentFunc)  // 设置变量作用域
-//    }
-//    return i
-//}
-
-// 声明: 变量
+// 报告名字name在pos处重复声明，other为之前的声明
+func (p *Parser) redeclared(name string, pos Pos, other int) {
+    p.reportAt(pos, fmt.Sprintf("%s redeclared in this block", name),
+        fmt.Sprintf("other declaration of %s at %s", name, p.sym.symbles[other].Pos))
+}
+
+// 类型名对应的类型，byte和uint8即char
+var basictypes = map[Token]Type{
+    INT:    VAR_INT,
+    CHAR:   VAR_CHAR,
+    BOOL:   VAR_BOOL,
+    INT8:   VAR_INT8,
+    INT16:  VAR_INT16,
+    INT32:  VAR_INT32,
+    INT64:  VAR_INT64,
+    UINT:   VAR_UINT,
+    UINT8:  VAR_CHAR,
+    UINT16: VAR_UINT16,
+    UINT32: VAR_UINT32,
+    UINT64: VAR_UINT64,
+    BYTE:   VAR_CHAR,
+    FLOAT:   VAR_FLOAT,
+    FLOAT32: VAR_FLOAT32,
+    FLOAT64: VAR_FLOAT,
+    STRING:  VAR_STRING,
+}
+
+// 形参的类型
+func (p *Parser) vartype(token Token, isPointer bool) Type {
+    t, ok := basictypes[token]
+    if !ok {
+        p.errorExpected("type")
+        return VAR_INT
+    }
+    if isPointer {
+        return pointerTo(t)
+    }
+    return t
+}
+
+// 添加第index个形参到函数的签名和符号表。由寄存器传入的形参在函数开头保存到栈帧中；
+// 其余形参由调用者压栈，位于返回地址之上。收集声明时只登记形参的类型
+func (p *Parser) addparam(token Token, n *ASTNode, isPointer bool, index int) int {
+    var i int
+    p.sym.AddParam(p.currentFunc, p.vartype(token, isPointer))
+    if p.collecting {
+        return -1
+    }
+    if reg, stack := argloc(p.sym.ParamTypes(p.currentFunc), index); reg != -1 {
+        i = p.addlocal(token, n, isPointer)
+    } else {
+        if id := p.sym.Findscope(n.litval); id != -1 {
+            p.redeclared(n.litval, n.pos, id)
+        }
+        i = p.sym.Addlocal(n.litval, p.vartype(token, isPointer))
+        p.sym.symbles[i].Pos = n.pos
+        p.sym.SetOffset(i, 16 + 8*stack)
+        p.sym.SetBelongFunc(i, p.currentFunc)
+    }
+    return i
+}
+
+// 声明: 变量 var identifier{, identifier} [var-type] [= exp-list]
+// child[0]为变量列表，child[1]为初始值列表。全局变量的初始值必须是常量
 func (p *Parser) var_declaration() *ASTNode {
-    t := NewASTNode(VarK)
+    t := p.newNode(VarK)
     p.match(VAR)
-    t.child[0] = NewASTNode(IdK)
-    t.child[0].litval = p.curLit
-    p.match(ID)
-    if p.curToken == MUL {
-        p.match(MUL)
-        if p.currentFunc == -1 {
-            t.child[0].symbleid = p.addglob(p.curToken, t.child[0].litval, true)
-        } else {
-            t.child[0].symbleid = p.addlocal(p.curToken, t.child[0].litval, true)
+    var names []*ASTNode
+    for {
+        n := p.newNode(IdK)
+        n.litval = p.curLit
+        p.match(ID)
+        names = append(names, n)
+        if p.curToken != COMMA {
+            break
         }
-    } else {
+        p.match(COMMA)
+    }
+
+    typed := p.curToken != ASSIGN
+    var vartype Type
+    if typed {
+        isPointer := false
+        if p.curToken == MUL {
+            p.match(MUL)
+            isPointer = true
+        }
+        vartype = p.vartype(p.curToken, isPointer)
+        p.match(p.curToken)
+    }
+    var types []Type
+    if p.curToken == ASSIGN {
+        p.match(ASSIGN)
+        t.child[1] = p.exp_list()
+        p.checkassign(t.pos, len(names), t.child[1])
+        types = p.inittypes(t.child[1], len(names))
+    }
+
+    if typed {
+        types = make([]Type, len(names))
+        for i := range types {
+            types[i] = vartype
+        }
+    }
+
+    // 初始值中的同名标识符引用的是外层的变量，所以在解析初始值之后才添加变量
+    for i, n := range names {
         if p.currentFunc == -1 {
-            t.child[0].symbleid = p.addglob(p.curToken, t.child[0].litval, false)
+            n.symbleid = p.declareglob(n.litval, n.pos, types[i])
         } else {
-            t.child[0].symbleid = p.addlocal(p.curToken, t.child[0].litval, false)
+            n.symbleid = p.declarelocal(n.litval, n.pos, types[i])
+        }
+        if i > 0 {
+            names[i-1].sibling = n
+        }
+    }
+    t.child[0] = names[0]
+
+    if p.currentFunc == -1 {
+        for e := t.child[1]; e != nil; e = e.sibling {
+            if !p.isstatic(e) {
+                p.reportAt(e.pos, "global initializer must be a constant expression")
+            }
         }
     }
-    //fmt.Println("222: ", t.child[0].symbleid, t.child[0].litval)
-    p.match(p.curToken)
     return t
 }
 
+// 全局变量的初始值：常量表达式或全局变量的地址
+func (p *Parser) isstatic(e *ASTNode) bool {
+    if isConst(e) {
+        return true
+    }
+    return e.nodeKind == UnaryOpK && e.token == AMPER && e.symbleid != -1 && !p.sym.symbles[e.symbleid].IsLocal
+}
+
 // 声明：函数
 func (p *Parser) func_declaration() *ASTNode {
     p.currentOffset = 0  // 新函数偏移量清0
-    t := NewASTNode(FuncK)
+    t := p.newNode(FuncK)
     p.match(FUNC)
     t.token = p.curToken  // ID 或 IDENT(main)
     t.litval = p.curLit   // 函数名
-    t.symbleid = Gsym.Addglob(t.litval, VAR_FUNC)  // 添加到符号表
+    t.symbleid = p.declareglob(t.litval, p.curPos, VAR_FUNC)  // 添加到符号表
     p.currentFunc = t.symbleid
+    if !p.collecting {
+        // 重新登记签名，并将形参和命名返回值添加为函数作用域中的变量
+        p.sym.symbles[t.symbleid].Params = nil
+        p.sym.symbles[t.symbleid].Results = nil
+    }
     p.match(p.curToken)
+    // 形参、命名返回值和函数体中最外层的变量位于同一个作用域
+    p.sym.Openscope()
     p.match(LPAREN)
-    // 参数解析，暂支持一个参数
-    if p.curToken == ID {
-        t.child[0] = NewASTNode(IdK)
-        t.child[0].litval = p.curLit
-        p.match(ID)
-        if p.curToken == MUL {
-            p.match(MUL)
-            t.child[0].symbleid = p.addlocal(p.curToken, t.child[0].litval, true)
+    index := 0
+    t.child[0] = p.name_list(func(token Token, n *ASTNode, isPointer bool) int {
+        index++
+        return p.addparam(token, n, isPointer, index-1)
+    })
+    p.match(RPAREN)
+    // 返回值解析：var-type | (var-type{, var-type}) | (identifier{, identifier} var-type{, ...})
+    p.results = nil
+    if p.curToken == LPAREN {
+        p.match(LPAREN)
+        if p.curToken == ID {
+            t.child[2] = p.name_list(func(token Token, n *ASTNode, isPointer bool) int {
+                p.sym.AddResult(t.symbleid, p.vartype(token, isPointer))
+                if p.collecting {
+                    return -1  // 收集声明时只登记返回值的类型
+                }
+                return p.addlocal(token, n, isPointer)
+            })
+            p.results = t.child[2]
         } else {
-            t.child[0].symbleid = p.addlocal(p.curToken, t.child[0].litval, false)
+            p.result_type(t.symbleid)
+            for p.curToken == COMMA {
+                p.match(COMMA)
+                p.result_type(t.symbleid)
+            }
         }
-        t.child[0].token = p.curToken  // 保存形参变量类型
-        p.match(p.curToken)
+        p.match(RPAREN)
+    } else if p.curToken != LBRACE {
+        p.result_type(t.symbleid)
     }
-    p.match(RPAREN)
-    // 返回值类型解析
-    if p.curToken != LBRACE {
-        switch p.curToken {
-        case CHAR:
-            Gsym.SetReturnType(t.symbleid, VAR_CHAR)
-        case INT:
-            Gsym.SetReturnType(t.symbleid, VAR_INT)
-        default:
-            p.error("not supported return type")
-        }
-        p.match(p.curToken)
+    if p.collecting {
+        p.sym.Closescope()
+        return t  // 函数体由collect跳过
     }
     p.match(LBRACE)
+    p.loops = nil
+    p.labels, p.gotos = make(map[string]*labelInfo), nil
     t.child[1] = p.stmt_sequence()
+    t.end = p.curPos
     p.match(RBRACE)
+    p.checklabels()
+    p.sym.Closescope()
+    p.currentFunc = -1
     return t
 }
 
-// 语句：返回语句
+// 匿名返回值的类型：[*]var-type
+func (p *Parser) result_type(fn int) {
+    isPointer := false
+    if p.curToken == MUL {
+        p.match(MUL)
+        isPointer = true
+    }
+    p.sym.AddResult(fn, p.vartype(p.curToken, isPointer))
+    p.match(p.curToken)
+}
+
+// 形参或命名返回值列表：identifier{, identifier} var-type{, identifier{, identifier} var-type}
+// 每个名字通过add添加到符号表，返回以兄弟节点相连的IdK节点
+func (p *Parser) name_list(add func(token Token, n *ASTNode, isPointer bool) int) *ASTNode {
+    var head, tail *ASTNode
+    var group []*ASTNode  // 共用同一类型的一组名字
+    for p.curToken == ID {
+        n := p.newNode(IdK)
+        n.litval = p.curLit
+        p.match(ID)
+        group = append(group, n)
+        if p.curToken == COMMA {
+            p.match(COMMA)
+            continue
+        }
+
+        isPointer := false
+        if p.curToken == MUL {
+            p.match(MUL)
+            isPointer = true
+        }
+        for _, n := range group {
+            n.symbleid = add(p.curToken, n, isPointer)
+            n.token = p.curToken  // 保存变量类型
+            if head == nil {
+                head = n
+            } else {
+                tail.sibling = n
+            }
+            tail = n
+        }
+        group = nil
+        p.match(p.curToken)
+        if p.curToken != COMMA {
+            break
+        }
+        p.match(COMMA)
+    }
+    if group != nil {
+        p.errorExpected("type")
+    }
+    return head
+}
+
+// 实参列表：[exp{, exp}]，返回以兄弟节点相连的表达式
+func (p *Parser) arg_list() *ASTNode {
+    if p.curToken == RPAREN {
+        return nil
+    }
+    return p.exp_list()
+}
+
+// 表达式列表：exp{, exp}，返回以兄弟节点相连的表达式
+func (p *Parser) exp_list() *ASTNode {
+    head := p.exp()
+    tail := head
+    for p.curToken == COMMA {
+        p.match(COMMA)
+        tail.sibling = p.exp()
+        tail = tail.sibling
+    }
+    return head
+}
+
+// 以兄弟节点相连的节点个数
+func listLen(t *ASTNode) int {
+    n := 0
+    for ; t != nil; t = t.sibling {
+        n++
+    }
+    return n
+}
+
+// 若表达式列表t只有一个函数调用，返回函数的插槽位置，否则返回-1
+func (p *Parser) singlecall(t *ASTNode) int {
+    if t == nil || t.sibling != nil || t.nodeKind != CallK || t.symbleid == -1 {
+        return -1
+    }
+    if p.sym.symbles[t.symbleid].Vartype != VAR_FUNC {
+        return -1
+    }
+    return t.symbleid
+}
+
+// 语句：返回语句 return [exp{, exp}]
 func (p *Parser) return_stmt() *ASTNode {
-    t := NewASTNode(ReturnK)
+    t := p.newNode(ReturnK)
     t.symbleid = p.currentFunc
+    line := p.curPos.Line
     p.match(RETURN)
-    t.child[0] = p.exp()
+    results := p.sym.symbles[p.currentFunc].Results
+    switch {
+    case p.curToken == SEMI || p.curToken == RBRACE || p.curToken == ENDFILE || p.curPos.Line > line:
+        // 不带表达式的return返回命名返回值的当前值
+        var tail *ASTNode
+        for r := p.results; r != nil; r = r.sibling {
+            n := p.newNode(IdK)
+            n.litval = r.litval
+            n.symbleid = r.symbleid
+            if tail == nil {
+                t.child[0] = n
+            } else {
+                tail.sibling = n
+            }
+            tail = n
+        }
+        if len(results) > 0 && p.results == nil {
+            p.reportAt(t.pos, "not enough return values")
+        }
+        return t
+    }
+    t.child[0] = p.exp_list()
+    n := listLen(t.child[0])
+    if fn := p.singlecall(t.child[0]); fn != -1 && len(p.sym.symbles[fn].Results) > 1 {
+        n = len(p.sym.symbles[fn].Results)  // return f() 返回f的全部返回值
+    } else {
+        for e := t.child[0]; e != nil; e = e.sibling {
+            p.checkvalue(e)
+        }
+    }
+    if n > len(results) {
+        p.reportAt(t.pos, "too many return values")
+    } else if n < len(results) {
+        p.reportAt(t.pos, "not enough return values")
+    }
     return t
 }
 
+// 检查函数调用的实参个数
+func (p *Parser) checkargs(t *ASTNode) {
+    fn := &p.sym.symbles[t.symbleid]
+    if fn.Vartype != VAR_FUNC {
+        p.reportAt(t.pos, fmt.Sprintf("cannot call non-function %s", t.litval))
+        return
+    }
+    n := listLen(t.child[0])
+    if g := p.singlecall(t.child[0]); g != -1 && len(p.sym.symbles[g].Results) > 1 {
+        n = len(p.sym.symbles[g].Results)  // f(g())将g的全部返回值作为实参
+    } else {
+        for a := t.child[0]; a != nil; a = a.sibling {
+            p.checkvalue(a)
+        }
+    }
+    if n < len(fn.Params) {
+        p.reportAt(t.pos, fmt.Sprintf("not enough arguments in call to %s", t.litval))
+    } else if n > len(fn.Params) {
+        p.reportAt(t.pos, fmt.Sprintf("too many arguments in call to %s", t.litval))
+    }
+}
+
+// 检查表达式t中的函数调用都恰好返回一个值
+func (p *Parser) checkvalue(t *ASTNode) {
+    if t == nil {
+        return
+    }
+    if t.nodeKind == CallK {
+        if t.symbleid == -1 || p.sym.symbles[t.symbleid].Vartype != VAR_FUNC {
+            return
+        }
+        switch results := p.sym.symbles[t.symbleid].Results; len(results) {
+        case 0:
+            p.reportAt(t.pos, fmt.Sprintf("%s() (no value) used as value", t.litval))
+        case 1:
+        default:
+            p.reportAt(t.pos, fmt.Sprintf("multiple-value %s() (value of type %s) in single-value context", t.litval, tupleString(results)))
+        }
+        return
+    }
+    for _, child := range t.child {
+        p.checkvalue(child)
+    }
+}
+
+// 多个返回值类型的字符串表示，如 (int, char)
+func tupleString(types []Type) string {
+    s := "("
+    for i, t := range types {
+        if i > 0 {
+            s += ", "
+        }
+        s += t.String()
+    }
+    return s + ")"
+}
+
+// 数量的字符串表示，如 1 value、2 values
+func plural(n int, what string) string {
+    if n == 1 {
+        return fmt.Sprintf("%d %s", n, what)
+    }
+    return fmt.Sprintf("%d %ss", n, what)
+}
+
 func (p *Parser) findvar(name string) (i int) {
-    i = Gsym.Findlocal(name)
+    i = p.sym.Findlocal(name)
     if i == -1 {
-        i = Gsym.Findglob(name)
+        i = p.sym.Findglob(name)
     }
     return
 }
 
-// 语句：赋值语句
-func (p *Parser) assign_stmt() *ASTNode {
-    t := NewASTNode(AssignK)
-    if p.curToken == MUL {
-        t.token = MUL
-        p.match(MUL)
+// 简单语句：赋值、短变量声明、自增自减或表达式。
+// 先解析表达式列表，再根据之后的记号确定语句的种类，表达式原样返回
+func (p *Parser) simple_stmt() *ASTNode {
+    // 短变量声明左边的新变量尚未定义，确定语句的种类后再报告未定义的名字
+    var undefs []undefinedName
+    p.undefs = &undefs
+    lhs := func() *ASTNode {
+        defer func() { p.undefs = nil }()
+        return p.exp_list()
+    }()
+    if p.curToken == DEFINE {
+        return p.define_stmt(lhs)  // 左边不是名字时由define_stmt报告
     }
-    t.litval = p.curLit
-    t.symbleid = p.findvar(t.litval)
-    if t.symbleid == -1 {
-        p.error("Parse error: use undefined variable")
+    for _, u := range undefs {
+        p.reportAt(u.pos, "undefined: " + u.name)
+    }
+
+    switch p.curToken {
+    case ASSIGN:
+        return p.assign_stmt(lhs)
+    case INC, DEC:
+        if lhs.sibling != nil {
+            p.errorExpected("= or comma")
+        }
+        return p.incdec_stmt(lhs)
+    }
+    if lhs.sibling != nil {
+        p.errorExpected("= or comma")
+    }
+    return lhs
+}
+
+// 记录未定义的名字
+func (p *Parser) undefined(pos Pos, name string) {
+    if p.undefs != nil {
+        *p.undefs = append(*p.undefs, undefinedName{pos, name})
+        return
+    }
+    p.reportAt(pos, "undefined: " + name)
+}
+
+// 语句：短变量声明 identifier{, identifier} := exp-list
+// 左边至少有一个新的变量，已在当前作用域中声明的变量被赋值。生成VarK节点
+func (p *Parser) define_stmt(lhs *ASTNode) *ASTNode {
+    t := p.newNode(VarK)
+    t.pos = lhs.pos
+    p.match(DEFINE)
+    t.child[1] = p.exp_list()
+    nvars := listLen(lhs)
+    p.checkassign(t.pos, nvars, t.child[1])
+    types := p.inittypes(t.child[1], nvars)
+
+    seen := make(map[string]bool)
+    isNew := false
+    i := 0
+    for n := lhs; n != nil; n, i = n.sibling, i+1 {
+        if n.nodeKind != IdK {
+            p.reportAt(n.pos, "non-name on left side of :=")
+            continue
+        }
+        if seen[n.litval] {
+            p.reportAt(n.pos, fmt.Sprintf("%s repeated on left side of :=", n.litval))
+            continue
+        }
+        seen[n.litval] = true
+        if id := p.sym.Findscope(n.litval); id != -1 {
+            n.symbleid = id
+            continue
+        }
+        n.symbleid = p.declarelocal(n.litval, n.pos, types[i])
+        isNew = true
+    }
+    if !isNew {
+        p.reportAt(t.pos, "no new variables on left side of :=")
+    }
+    t.child[0] = lhs
+    return t
+}
+
+// 初始值的类型，用于推导变量的类型
+func (p *Parser) inittypes(rhs *ASTNode, nvars int) []Type {
+    types := make([]Type, nvars)
+    if fn := p.singlecall(rhs); fn != -1 && len(p.sym.symbles[fn].Results) > 1 {
+        copy(types, p.sym.symbles[fn].Results)
+        return types
+    }
+    i := 0
+    for e := rhs; e != nil && i < nvars; e, i = e.sibling, i+1 {
+        types[i] = p.exptype(e)
+    }
+    for ; i < nvars; i++ {
+        types[i] = VAR_INT
+    }
+    return types
+}
+
+// 表达式的类型
+func (p *Parser) exptype(t *ASTNode) Type {
+    switch t.nodeKind {
+    case ConstK:
+        if t.token == TRUE || t.token == FALSE {
+            return VAR_BOOL
+        }
+        if t.token == FNUM {
+            return VAR_FLOAT
+        }
+        if t.token == STRLIT {
+            return VAR_STRING
+        }
+    case IndexK:
+        return VAR_CHAR
+    case SliceK:
+        return VAR_STRING
+    case ConvK:
+        return basictypes[t.token]
+    case IdK:
+        if t.symbleid != -1 {
+            return p.sym.symbles[t.symbleid].Vartype
+        }
+    case CallK:
+        if fn := t.symbleid; fn != -1 && len(p.sym.symbles[fn].Results) > 0 {
+            return p.sym.symbles[fn].Results[0]
+        }
+    case UnaryOpK:
+        switch t.token {
+        case AMPER:
+            return pointerTo(p.exptype(t.child[0]))
+        case MUL:
+            if typ := p.exptype(t.child[0]); isPointer(typ) {
+                return elemType(typ)
+            }
+            return VAR_INT
+        case NOT:
+            return VAR_BOOL
+        }
+        return p.exptype(t.child[0])
+    case OpK:
+        switch t.token {
+        case EQ, NE, LT, LE, GT, GE, AND, OR:
+            return VAR_BOOL
+        case SHL, SHR:
+            return p.exptype(t.child[0])
+        }
+        // 常量的类型由另一个操作数决定，两个操作数都是常量时浮点数优先
+        kx, ky := untypedKind(t.child[0]), untypedKind(t.child[1])
+        if kx == UNTYPED_FLOAT && ky != VAR_INVALID || ky == UNTYPED_FLOAT && kx != VAR_INVALID {
+            return VAR_FLOAT
+        }
+        if kx != VAR_INVALID {
+            return p.exptype(t.child[1])
+        }
+        return p.exptype(t.child[0])
+    }
+    return VAR_INT
+}
+
+// 未定类型的数值常量表达式的类型：UNTYPED_INT或UNTYPED_FLOAT，t不是这样的表达式时为VAR_INVALID
+func untypedKind(t *ASTNode) Type {
+    switch t.nodeKind {
+    case ConstK:
+        switch t.token {
+        case TRUE, FALSE, STRLIT:
+            return VAR_INVALID
+        case FNUM:
+            return UNTYPED_FLOAT
+        }
+        return UNTYPED_INT
+    case UnaryOpK:
+        if t.token == ADD || t.token == SUB || t.token == XOR {
+            return untypedKind(t.child[0])
+        }
+    case OpK:
+        switch t.token {
+        case ADD, SUB, MUL, QUO, REM, AMPER, PIPE, XOR, ANDNOT:
+            kx, ky := untypedKind(t.child[0]), untypedKind(t.child[1])
+            if kx == VAR_INVALID || ky == VAR_INVALID {
+                return VAR_INVALID
+            }
+            if kx == UNTYPED_FLOAT || ky == UNTYPED_FLOAT {
+                return UNTYPED_FLOAT
+            }
+            return UNTYPED_INT
+        case SHL, SHR:
+            return untypedKind(t.child[0])
+        }
+    }
+    return VAR_INVALID
+}
+
+// 检查作为语句的简单语句：表达式只能是函数调用
+func (p *Parser) checkstmt(t *ASTNode) {
+    switch t.nodeKind {
+    case AssignK, VarK, CallK:
+    case IdK:
+        p.reportAt(t.pos, fmt.Sprintf("%s is not used", t.litval))
+    default:
+        p.reportAt(t.pos, "expression is not used")
+    }
+}
+
+// 检查n可以被赋值：变量或*变量
+func (p *Parser) checkassignable(n *ASTNode) {
+    if n.nodeKind != IdK && !(n.nodeKind == UnaryOpK && n.token == MUL) {
+        p.reportAt(n.pos, "cannot assign to expression")
+    }
+}
+
+// 语句：赋值语句 lhs{, lhs} = exp{, exp}，lhs为已解析的变量列表。
+// child[0]为被赋值的变量列表，child[1]为表达式列表
+func (p *Parser) assign_stmt(lhs *ASTNode) *ASTNode {
+    t := p.newNode(AssignK)
+    t.pos = lhs.pos
+    t.child[0] = lhs
+    for n := lhs; n != nil; n = n.sibling {
+        p.checkassignable(n)
     }
-    p.match(ID)
     p.match(ASSIGN)
-    t.child[0] = p.exp()
+    t.child[1] = p.exp_list()
+    p.checkassign(t.pos, listLen(t.child[0]), t.child[1])
+    return t
+}
+
+// 检查nvars个变量与值列表rhs的个数是否一致
+func (p *Parser) checkassign(pos Pos, nvars int, rhs *ASTNode) {
+    if fn := p.singlecall(rhs); fn != -1 && (nvars > 1 || len(p.sym.symbles[fn].Results) > 1) {
+        if n := len(p.sym.symbles[fn].Results); n != nvars {
+            p.reportAt(pos, fmt.Sprintf("assignment mismatch: %s but %s() returns %s",
+                plural(nvars, "variable"), rhs.litval, plural(n, "value")))
+        }
+        return
+    }
+    for n := rhs; n != nil; n = n.sibling {
+        p.checkvalue(n)
+    }
+    if n := listLen(rhs); n != nvars {
+        p.reportAt(pos, fmt.Sprintf("assignment mismatch: %s but %s",
+            plural(nvars, "variable"), plural(n, "value")))
+    }
+}
+
+// 语句：x++ 和 x--，转换为赋值语句 x = x + 1 和 x = x - 1
+func (p *Parser) incdec_stmt(x *ASTNode) *ASTNode {
+    t := p.newNode(AssignK)
+    t.pos = x.pos
+    p.checkassignable(x)
+    op := p.newNode(OpK)
+    op.token = ADD
+    if p.curToken == DEC {
+        op.token = SUB
+    }
+    p.match(p.curToken)
+    value := *x  // 作为表达式读取x的值
+    op.child[0] = &value
+    op.child[1] = p.newNode(ConstK)
+    op.child[1].intval = 1
+    t.child[0] = x
+    t.child[1] = op
     return t
 }
 
 // 语句：输出语句
 func (p *Parser) print_stmt() *ASTNode {
-    t := NewASTNode(PrintK)
+    t := p.newNode(PrintK)
     p.match(PRINT)
     t.child[0] = p.exp()
+    p.checkvalue(t.child[0])
     return t
 }
 
-// 语句：条件语句
+// 语句：条件语句 if [simple-stmt;] exp block [else (if-stmt | block)]。
+// child[0]为条件，child[1]和child[2]为两个分支，child[3]为init语句。
+// if语句有自己的作用域，init语句声明的变量在两个分支中都可见
 func (p *Parser) if_stmt() *ASTNode {
-    t := NewASTNode(IfK)
+    t := p.newNode(IfK)
     p.match(IF)
-    t.child[0] = p.exp()
-    p.match(LBRACE)
-    t.child[1] = p.stmt_sequence()
-    p.match(RBRACE)
+    p.sym.Openscope()
+    if p.curToken == SEMI {
+        p.match(SEMI)
+    } else {
+        t.child[0] = p.simple_stmt()
+        if p.curToken == SEMI {
+            p.match(SEMI)
+            p.checkstmt(t.child[0])
+            t.child[3], t.child[0] = t.child[0], nil
+        }
+    }
+    if t.child[0] == nil && p.curToken != LBRACE {
+        t.child[0] = p.exp()
+    }
+    if t.child[0] == nil || t.child[0].nodeKind == AssignK || t.child[0].nodeKind == VarK {
+        p.reportAt(t.pos, "missing condition in if statement")
+    }
+    p.checkvalue(t.child[0])
+    t.child[1] = p.block()
     if p.curToken == ELSE {
         p.match(ELSE)
-        p.match(LBRACE)
-        t.child[2] = p.stmt_sequence()
-        p.match(RBRACE)
+        if p.curToken == IF {
+            t.child[2] = p.if_stmt()
+        } else {
+            t.child[2] = p.block()
+        }
     }
+    p.sym.Closescope()
     return t
 }
 
-// 语句：循环语句
-func (p *Parser) for_stmt() *ASTNode {
-    t := NewASTNode(ForK)
+// 语句：循环语句，label为循环的标签。
+// child[0]为循环条件（无条件时为nil），child[1]为循环体，child[2]和child[3]为init和post语句
+func (p *Parser) for_stmt(label string) *ASTNode {
+    t := p.newNode(ForK)
+    t.litval = label
     p.match(FOR)
-    t.child[0] = p.exp()
+    // for语句有自己的作用域，init语句声明的变量只在循环中可见
+    p.sym.Openscope()
+    if p.curToken != LBRACE {
+        var init *ASTNode
+        if p.curToken != SEMI {
+            init = p.simple_stmt()
+        }
+        if p.curToken == LBRACE {
+            // for cond {}
+            if init.nodeKind == AssignK || init.nodeKind == VarK {
+                p.reportAt(init.pos, "expected for loop condition")
+            }
+            t.child[0] = init
+        } else {
+            // for init; cond; post {}
+            p.match(SEMI)
+            if init != nil {
+                p.checkstmt(init)
+            }
+            t.child[2] = init
+            if p.curToken != SEMI {
+                t.child[0] = p.exp()
+            }
+            p.match(SEMI)
+            if p.curToken != LBRACE {
+                t.child[3] = p.simple_stmt()
+                if t.child[3].nodeKind == VarK {
+                    p.reportAt(t.child[3].pos, "cannot declare in post statement of for loop")
+                }
+                p.checkstmt(t.child[3])
+            }
+        }
+        p.checkvalue(t.child[0])
+    }
+    p.loops = append(p.loops, label)
+    t.child[1] = p.block()
+    p.loops = p.loops[:len(p.loops)-1]
+    p.sym.Closescope()
+    return t
+}
+
+// 语句块：{ stmt-sequence }，语句块是一个新的作用域
+func (p *Parser) block() *ASTNode {
     p.match(LBRACE)
-    t.child[1] = p.stmt_sequence()
+    p.sym.Openscope()
+    t := p.stmt_sequence()
+    p.sym.Closescope()
     p.match(RBRACE)
     return t
 }
 
+// 语句：带标签的语句 identifier : [statement]
+func (p *Parser) labeled_stmt() *ASTNode {
+    t := p.newNode(LabelK)
+    t.litval = p.curLit
+    p.match(ID)
+    p.match(COLON)
+    if l, ok := p.labels[t.litval]; ok {
+        p.reportAt(t.pos, fmt.Sprintf("label %s already defined at %s", t.litval, l.pos))
+    } else if p.labels != nil {
+        p.labels[t.litval] = &labelInfo{pos: t.pos, scopes: p.sym.Scopes()}
+    }
+    switch p.curToken {
+    case RBRACE, SEMI:
+    case FOR:
+        t.child[0] = p.for_stmt(t.litval)
+    default:
+        t.child[0] = p.statement()
+    }
+    return t
+}
+
+// 语句：break和continue，可以带外层循环的标签
+func (p *Parser) branch_stmt() *ASTNode {
+    var t *ASTNode
+    if p.curToken == BREAK {
+        t = p.newNode(BreakK)
+    } else {
+        t = p.newNode(ContinueK)
+    }
+    what := p.curToken.String()
+    line := p.curPos.Line
+    p.match(p.curToken)
+    if p.curToken == ID && p.curPos.Line == line {
+        t.litval = p.curLit
+        p.match(ID)
+    }
+
+    if t.litval == "" {
+        if len(p.loops) == 0 {
+            if t.nodeKind == BreakK {
+                p.reportAt(t.pos, "break is not in a loop, switch, or select")
+            } else {
+                p.reportAt(t.pos, "continue is not in a loop")
+            }
+        }
+        return t
+    }
+    l, ok := p.labels[t.litval]
+    if !ok {
+        p.reportAt(t.pos, fmt.Sprintf("%s label not defined: %s", what, t.litval))
+        return t
+    }
+    l.used = true
+    for _, name := range p.loops {
+        if name == t.litval {
+            return t
+        }
+    }
+    p.reportAt(t.pos, fmt.Sprintf("invalid %s label %s", what, t.litval))
+    return t
+}
+
+// 语句：goto，标签可以在goto之后定义，在函数结束时检查
+func (p *Parser) goto_stmt() *ASTNode {
+    t := p.newNode(GotoK)
+    p.match(GOTO)
+    t.litval = p.curLit
+    p.match(ID)
+    p.gotos = append(p.gotos, gotoInfo{node: t, scopes: p.sym.Scopes()})
+    return t
+}
+
+// 检查当前函数中goto的目标标签，以及未使用的标签
+func (p *Parser) checklabels() {
+    for _, g := range p.gotos {
+        l, ok := p.labels[g.node.litval]
+        if !ok {
+            p.reportAt(g.node.pos, fmt.Sprintf("label %s not defined", g.node.litval))
+            continue
+        }
+        l.used = true
+        // 标签所在的语句块必须包含goto语句
+        if !enclosing(l.scopes, g.scopes) {
+            p.reportAt(g.node.pos, fmt.Sprintf("goto %s jumps into block", g.node.litval))
+            continue
+        }
+        // 向后跳转不能跳过标签所在语句块中的变量声明
+        if d := p.jumpedover(g.node.pos, l); d != -1 {
+            p.reportAt(g.node.pos, fmt.Sprintf("goto %s jumps over variable declaration at line %d",
+                g.node.litval, p.sym.symbles[d].Pos.Line))
+        }
+    }
+    var unused []string
+    for name, l := range p.labels {
+        if !l.used {
+            unused = append(unused, name)
+        }
+    }
+    sort.Slice(unused, func(i, j int) bool {
+        return p.labels[unused[i]].pos.Offset < p.labels[unused[j]].pos.Offset
+    })
+    for _, name := range unused {
+        p.reportAt(p.labels[name].pos, fmt.Sprintf("label %s defined and not used", name))
+    }
+}
+
+// 作用域路径outer是否为inner的前缀，即outer的语句块包含inner的语句块
+func enclosing(outer, inner []int) bool {
+    if len(outer) > len(inner) {
+        return false
+    }
+    for i := range outer {
+        if outer[i] != inner[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// 从pos处的goto跳转到其后的标签l时跳过的最后一个变量声明，这些变量在标签处仍然可见。
+// 没有跳过变量声明时返回-1
+func (p *Parser) jumpedover(pos Pos, l *labelInfo) int {
+    scope := l.scopes[len(l.scopes)-1]
+    d := -1
+    for _, i := range p.sym.locals {
+        if v := p.sym.symbles[i]; v.Scope == scope && v.Pos.Offset > pos.Offset && v.Pos.Offset < l.pos.Offset {
+            d = i
+        }
+    }
+    return d
+}
 
-// 表达式： == < >
+// 表达式：||
 func (p *Parser) exp() *ASTNode {
+    t := p.and_exp()
+    for p.curToken == OR {
+        n := p.newNode(OpK)
+        n.child[0] = t
+        n.token = p.curToken
+        t = n
+        p.match(p.curToken)
+        n.child[1] = p.and_exp()
+    }
+    return t
+}
+
+// 表达式：&&
+func (p *Parser) and_exp() *ASTNode {
+    t := p.comparison_exp()
+    for p.curToken == AND {
+        n := p.newNode(OpK)
+        n.child[0] = t
+        n.token = p.curToken
+        t = n
+        p.match(p.curToken)
+        n.child[1] = p.comparison_exp()
+    }
+    return t
+}
+
+// 表达式：== != < <= > >=
+func (p *Parser) comparison_exp() *ASTNode {
     t := p.simple_exp()
-    if p.curToken == EQ || p.curToken == LT || p.curToken == GT || p.curToken == GE || p.curToken == LE || p.curToken == NE {
-        n := NewASTNode(OpK)
+    for p.curToken == EQ || p.curToken == LT || p.curToken == GT || p.curToken == GE || p.curToken == LE || p.curToken == NE {
+        n := p.newNode(OpK)
         n.child[0] = t
         n.token = p.curToken
         t = n
@@ -384,11 +1465,11 @@ func (p *Parser) exp() *ASTNode {
     return t
 }
 
-// 表达式：+ -
+// 表达式：+ - | ^
 func (p *Parser) simple_exp() *ASTNode {
     t := p.term()
-    for p.curToken == ADD || p.curToken == SUB {
-        n := NewASTNode(OpK)
+    for p.curToken == ADD || p.curToken == SUB || p.curToken == PIPE || p.curToken == XOR {
+        n := p.newNode(OpK)
         n.child[0] = t
         n.token = p.curToken
         t = n
@@ -398,16 +1479,61 @@ func (p *Parser) simple_exp() *ASTNode {
     return t
 }
 
-// 表达式：* /
+// 表达式：* / % << >> & &^
 func (p *Parser) term() *ASTNode {
-    t := p.factor()
-    for p.curToken == MUL || p.curToken == QUO {
-        n := NewASTNode(OpK)
+    t := p.unary_exp()
+    for p.curToken == MUL || p.curToken == QUO || p.curToken == REM || p.curToken == SHL ||
+        p.curToken == SHR || p.curToken == AMPER || p.curToken == ANDNOT {
+        n := p.newNode(OpK)
         n.child[0] = t
         n.token = p.curToken
         t = n
         p.match(p.curToken)
-        n.child[1] = p.factor()
+        n.child[1] = p.unary_exp()
+    }
+    return t
+}
+
+// 表达式：一元运算符 + - ! ^
+func (p *Parser) unary_exp() *ASTNode {
+    switch p.curToken {
+    case ADD, SUB, NOT, XOR:
+        t := p.newNode(UnaryOpK)
+        t.token = p.curToken
+        p.match(p.curToken)
+        t.child[0] = p.unary_exp()
+        return t
+    }
+    return p.primary_exp()
+}
+
+// 表达式：factor之后的下标 x[i] 和切片 x[lo:hi]
+func (p *Parser) primary_exp() *ASTNode {
+    t := p.factor()
+    for p.curToken == LBRACK {
+        var n, index *ASTNode
+        pos := p.curPos
+        p.match(LBRACK)
+        if p.curToken != COLON {
+            index = p.exp()
+            p.checkvalue(index)
+        }
+        if p.curToken == COLON {
+            n = p.newNode(SliceK)
+            n.child[1] = index
+            p.match(COLON)
+            if p.curToken != RBRACK {
+                n.child[2] = p.exp()
+                p.checkvalue(n.child[2])
+            }
+        } else {
+            n = p.newNode(IndexK)
+            n.child[1] = index
+        }
+        n.pos = pos
+        n.child[0] = t
+        p.match(RBRACK)
+        t = n
     }
     return t
 }
@@ -417,39 +1543,56 @@ func (p *Parser) factor() *ASTNode {
     var t *ASTNode = nil
     switch p.curToken {
     case NUM:
-        t = NewASTNode(ConstK)
-        t.intval, _ = strconv.Atoi(p.curLit)
+        t = p.newNode(ConstK)
+        t.litval = p.curLit  // 常量的精确值，超出64位时由Checker报告
+        t.intval, _ = constValue(t)
         p.match(NUM)
+    case FNUM:
+        t = p.newNode(ConstK)
+        t.token = FNUM
+        t.litval = p.curLit  // 常量的精确值由源代码计算
+        t.fval, _ = strconv.ParseFloat(p.curLit, 64)
+        p.match(FNUM)
+    case TRUE, FALSE:
+        // 布尔常量，token区分它与整数常量
+        t = p.newNode(ConstK)
+        t.token = p.curToken
+        t.intval = boolValue(p.curToken == TRUE)
+        p.match(p.curToken)
+    case STRLIT:
+        t = p.newNode(ConstK)
+        t.token = STRLIT
+        t.litval, _ = strconv.Unquote(p.curLit)  // 转义序列已由扫描器检查
+        p.match(STRLIT)
     case ID:
-        if p.prev() == LPAREN {
-            t = NewASTNode(CallK)
+        if p.curLit == "len" && p.prev() == LPAREN && p.findvar("len") == -1 {
+            // 内置函数len，可以被同名的变量或函数遮蔽
+            t = p.newNode(LenK)
+            p.match(ID)
+            p.match(LPAREN)
+            t.child[0] = p.exp()
+            p.checkvalue(t.child[0])
+            p.match(RPAREN)
+        } else if p.prev() == LPAREN {
+            t = p.newNode(CallK)
             t.litval = p.curLit  // 函数名
-            t.symbleid = Gsym.Findglob(t.litval)
+            t.symbleid = p.findvar(t.litval)  // 局部变量遮蔽同名的函数
             if t.symbleid == -1 {
-                p.error("Parse error: use undefined var")
+                p.undefined(t.pos, t.litval)
             }
             p.match(ID)
             p.match(LPAREN)
-            switch p.curToken {
-            case ID:
-                t.child[0] = NewASTNode(IdK)
-                t.child[0].litval = p.curLit  // 变量名
-                t.child[0].symbleid = p.findvar(p.curLit)
-                if t.symbleid == -1 {
-                    p.error("Parse error: use undefined var")
-                }
-            case NUM:
-                t.child[0] = NewASTNode(ConstK)
-                t.child[0].intval, _ = strconv.Atoi(p.curLit)
-            }
-            p.match(p.curToken)
+            t.child[0] = p.arg_list()
             p.match(RPAREN)
+            if t.symbleid != -1 {
+                p.checkargs(t)
+            }
         } else {
-            t = NewASTNode(IdK)
+            t = p.newNode(IdK)
             t.litval = p.curLit
             t.symbleid = p.findvar(t.litval)
             if t.symbleid == -1 {
-                p.error("Parse error: use undefined var")
+                p.undefined(t.pos, t.litval)
             }
             p.match(ID)
         }
@@ -458,19 +1601,37 @@ func (p *Parser) factor() *ASTNode {
         t = p.exp()
         p.match(RPAREN)
     case MUL, AMPER:
-        t = NewASTNode(UnaryOpK)
+        t = p.newNode(UnaryOpK)
         t.token = p.curToken
         p.match(p.curToken)
-        t.child[0] = NewASTNode(IdK)
+        t.child[0] = p.newNode(IdK)
         t.litval = p.curLit
         t.symbleid = p.findvar(p.curLit)
         t.child[0].litval = p.curLit
         t.child[0].symbleid = p.findvar(p.curLit)
+        if p.curToken == ID && t.symbleid == -1 {
+            p.undefined(p.curPos, p.curLit)
+        }
         p.match(ID)
     default:
-        p.error("Error: undefined token")
+        if _, ok := basictypes[p.curToken]; ok && p.prev() == LPAREN {
+            return p.conversion()
+        }
+        p.errorExpected("expression")
     }
     return t
 }
 
+// 类型转换：type-name(exp)，token为目标类型
+func (p *Parser) conversion() *ASTNode {
+    t := p.newNode(ConvK)
+    t.token = p.curToken
+    p.match(p.curToken)
+    p.match(LPAREN)
+    t.child[0] = p.exp()
+    p.checkvalue(t.child[0])
+    p.match(RPAREN)
+    return t
+}
+
 
@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestParseString 解析内存中的源代码，检查返回的语法树和诊断信息
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		decls []NodeKind // 期望的顶层声明
+		errs  []string   // 期望的诊断信息，按位置排序
+	}{
+		{
+			name: "empty",
+			src:  "",
+		},
+		{
+			name:  "main",
+			src:   "var g int = 1\n\nfunc main() {\n\tprint g\n}\n",
+			decls: []NodeKind{VarK, FuncK},
+		},
+		{
+			name: "syntax",
+			src:  "func main() {\n\tprint (1\n}\n",
+			errs: []string{"input.mygo:3:1: syntax error: unexpected }, expected )"},
+		},
+		{
+			name: "many",
+			src:  "x := 1\n\nfunc main() {\n\tprint y\n\tfunc f() {\n\t}\n}\n",
+			errs: []string{
+				"input.mygo:1:1: syntax error: non-declaration statement outside function body",
+				"input.mygo:4:8: undefined: y",
+				"input.mygo:5:2: function declaration not allowed inside function body",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewCompilation(Options{})
+			tree, err := ctx.ParseString("input.mygo", tt.src)
+			if tt.errs == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(ctx.Diagnostics()) != 0 {
+					t.Errorf("unexpected diagnostics: %v", ctx.Diagnostics())
+				}
+				var kinds []NodeKind
+				for n := tree; n != nil; n = n.sibling {
+					kinds = append(kinds, n.nodeKind)
+				}
+				if len(kinds) != len(tt.decls) {
+					t.Fatalf("got declarations %v, want %v", kinds, tt.decls)
+				}
+				for i := range kinds {
+					if kinds[i] != tt.decls[i] {
+						t.Errorf("got declarations %v, want %v", kinds, tt.decls)
+						break
+					}
+				}
+				return
+			}
+
+			if tree != nil {
+				t.Errorf("got a tree for erroneous source")
+			}
+			var diags DiagnosticList
+			if !errors.As(err, &diags) {
+				t.Fatalf("error is %T (%v), want DiagnosticList", err, err)
+			}
+			if len(diags) != len(tt.errs) {
+				t.Fatalf("got %d diagnostics, want %d:\n%v", len(diags), len(tt.errs), diags)
+			}
+			for i, d := range diags {
+				if d.Error() != tt.errs[i] {
+					t.Errorf("diagnostic %d is %q, want %q", i, d.Error(), tt.errs[i])
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package compiler
 
 import (
     "fmt"
+    "io"
     "strings"
 )
 
@@ -19,6 +20,11 @@ const (
     AssignK
     PrintK
     ReturnK
+    BreakK
+    ContinueK
+    GotoK
+    LabelK     // 带标签的语句
+    BlockK     // 语句块
 
     // 表达式类型
     OpK
@@ -26,6 +32,10 @@ const (
     IdK
     CallK
     UnaryOpK  // 一元运算符
+    ConvK     // 类型转换，token为目标类型
+    IndexK    // 字符串的下标 s[i]
+    SliceK    // 字符串的切片 s[lo:hi]，省略的下标为nil
+    LenK      // 内置函数len
 )
 
 // 语法树
@@ -36,20 +46,28 @@ type ASTNode struct {
     nodeKind NodeKind  // 节点类型
     token Token
     intval int     // 数字
-    litval string  // 标识符名
+    fval float64   // 浮点数，token为FNUM
+    litval string  // 标识符名，token为STRLIT时为字符串的值
     symbleid int   // 标识符的插槽位置
+    vartype Type   // 表达式的类型，由Checker标注
+    pos Pos        // 节点在源代码中的位置
+    end Pos        // 函数体的右花括号的位置
 }
 
 func NewASTNode(nodeKind NodeKind) *ASTNode {
     var childLen int
     switch nodeKind {
-    case IfK, FuncK:
+    case IfK, ForK:
+        childLen = 4
+    case FuncK:
+        childLen = 3
+    case SliceK:
         childLen = 3
-    case OpK, ForK:
+    case OpK, AssignK, VarK, IndexK:
         childLen = 2
-    case ConstK:
+    case ConstK, BreakK, ContinueK, GotoK:
         childLen = 0
-    case PrintK, VarK, AssignK, ReturnK, CallK, UnaryOpK:
+    case PrintK, ReturnK, CallK, UnaryOpK, LabelK, BlockK, ConvK, LenK:
         childLen = 1
     }
 
@@ -65,44 +83,86 @@ func NewASTNode(nodeKind NodeKind) *ASTNode {
     }
 }
 
-func (t *ASTNode) printTree(level int) {
+// Pos 返回节点在源代码中的位置
+func (t *ASTNode) Pos() Pos {
+    return t.pos
+}
+
+func (t *ASTNode) printTree(w io.Writer, level int) {
     tab := strings.Repeat(" ", level)
     switch t.nodeKind {
     case OpK:
-        fmt.Printf("%sOp: %s\n", tab, tokens[t.token])
+        fmt.Fprintf(w, "%sOp: %s\n", tab, tokens[t.token])
     case ConstK:
-        fmt.Printf("%sConst: %d\n", tab, t.intval)
+        if t.token == FNUM {
+            fmt.Fprintf(w, "%sConst: %g\n", tab, t.fval)
+        } else if t.token == STRLIT {
+            fmt.Fprintf(w, "%sConst: %q\n", tab, t.litval)
+        } else {
+            fmt.Fprintf(w, "%sConst: %d\n", tab, t.intval)
+        }
     case IdK:
-        fmt.Printf("%sId: %s\n", tab, t.litval)
+        fmt.Fprintf(w, "%sId: %s\n", tab, t.litval)
     case AssignK:
-        fmt.Printf("%sAssign: %s\n", tab, t.litval)
+        fmt.Fprintf(w, "%sAssign:\n", tab)
+        t.child[0].printTree(w, level+4)
+        fmt.Fprintf(w, "%s=\n", tab)
+        t.child[1].printTree(w, level+4)
+        goto next
+    case UnaryOpK:
+        fmt.Fprintf(w, "%sUnary: %s\n", tab, tokens[t.token])
+    case ConvK:
+        fmt.Fprintf(w, "%sConv: %s\n", tab, basictypes[t.token])
+    case CallK:
+        fmt.Fprintf(w, "%sCall: %s\n", tab, t.litval)
+    case IndexK:
+        fmt.Fprintf(w, "%sIndex:\n", tab)
+    case SliceK:
+        fmt.Fprintf(w, "%sSlice:\n", tab)
+    case LenK:
+        fmt.Fprintf(w, "%sLen:\n", tab)
+    case ReturnK:
+        fmt.Fprintf(w, "%sReturn:\n", tab)
+    case BreakK:
+        fmt.Fprintf(w, "%sBreak: %s\n", tab, t.litval)
+    case ContinueK:
+        fmt.Fprintf(w, "%sContinue: %s\n", tab, t.litval)
+    case GotoK:
+        fmt.Fprintf(w, "%sGoto: %s\n", tab, t.litval)
+    case LabelK:
+        fmt.Fprintf(w, "%sLabel: %s\n", tab, t.litval)
+    case BlockK:
+        fmt.Fprintf(w, "%sBlock:\n", tab)
     case PrintK:
-        fmt.Printf("%sPrint:\n", tab)
+        fmt.Fprintf(w, "%sPrint:\n", tab)
     case VarK:
-        fmt.Printf("%sVar:\n", tab)
+        fmt.Fprintf(w, "%sVar:\n", tab)
     case IfK:
-        fmt.Printf("%sIf:\n", tab)
-        for id, child := range t.child {
+        fmt.Fprintf(w, "%sIf:\n", tab)
+        if t.child[3] != nil {
+            t.child[3].printTree(w, level+4)  // init语句
+        }
+        for id, child := range t.child[:3] {
             if child != nil {
                 if id == 2 {
-                    fmt.Printf("%sELSE:\n", tab)
+                    fmt.Fprintf(w, "%sELSE:\n", tab)
                 }
-                child.printTree(level+4)
+                child.printTree(w, level+4)
             }
         }
         goto next
     case ForK:
-        fmt.Printf("%sFor:\n", tab)
+        fmt.Fprintf(w, "%sFor:\n", tab)
     case FuncK:
-        fmt.Printf("%sFunc: %s\n", tab, t.litval)
+        fmt.Fprintf(w, "%sFunc: %s\n", tab, t.litval)
     }
     for _, child := range t.child {
         if child != nil {
-            child.printTree(level+4)
+            child.printTree(w, level+4)
         }
     }
 next:
     if t.sibling != nil {
-        t.sibling.printTree(level)
+        t.sibling.printTree(w, level)
     }
 }
\ No newline at end of file
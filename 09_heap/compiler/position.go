@@ -0,0 +1,108 @@
+package compiler
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Pos 源代码中的一个位置
+type Pos struct {
+    File   string  // 文件名
+    Offset int     // 字节偏移量，从0开始
+    Line   int     // 行号，从1开始，0表示位置未知
+    Column int     // 列号（字节），从1开始，0表示未知
+}
+
+func (pos Pos) IsValid() bool {
+    return pos.Line > 0
+}
+
+// String 按 file:line:col 的格式返回位置，未知的部分省略
+func (pos Pos) String() string {
+    s := pos.File
+    if pos.IsValid() {
+        if s != "" {
+            s += ":"
+        }
+        s += fmt.Sprintf("%d", pos.Line)
+        if pos.Column > 0 {
+            s += fmt.Sprintf(":%d", pos.Column)
+        }
+    }
+    if s == "" {
+        s = "-"
+    }
+    return s
+}
+
+// File 记录一个源文件已读入的内容及每行的起始偏移量
+type File struct {
+    name  string
+    src   strings.Builder
+    lines []int  // 每行起始位置的字节偏移量
+}
+
+func (f *File) Name() string {
+    return f.name
+}
+
+// 追加一行源代码，返回该行起始位置的偏移量
+func (f *File) addLine(line string) int {
+    offset := f.src.Len()
+    f.lines = append(f.lines, offset)
+    f.src.WriteString(line)
+    return offset
+}
+
+// LineCount 返回已读入的行数
+func (f *File) LineCount() int {
+    return len(f.lines)
+}
+
+// Line 返回第n行（从1开始）的内容，不含换行符
+func (f *File) Line(n int) (string, bool) {
+    if n <= 0 || n > len(f.lines) {
+        return "", false
+    }
+    src := f.src.String()
+    end := len(src)
+    if n < len(f.lines) {
+        end = f.lines[n]
+    }
+    return strings.TrimRight(src[f.lines[n-1]:end], "\r\n"), true
+}
+
+// FileSet 一组源文件，用于将位置映射回源代码
+type FileSet struct {
+    files []*File
+}
+
+func NewFileSet() *FileSet {
+    return &FileSet{}
+}
+
+// AddFile 添加一个名为name的源文件
+func (s *FileSet) AddFile(name string) *File {
+    f := &File{name: name}
+    s.files = append(s.files, f)
+    return f
+}
+
+// File 返回名为name的源文件，不存在时返回nil
+func (s *FileSet) File(name string) *File {
+    for _, f := range s.files {
+        if f.name == name {
+            return f
+        }
+    }
+    return nil
+}
+
+// Line 返回位置pos所在的源代码行
+func (s *FileSet) Line(pos Pos) (string, bool) {
+    f := s.File(pos.File)
+    if f == nil {
+        return "", false
+    }
+    return f.Line(pos.Line)
+}
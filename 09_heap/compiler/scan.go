@@ -2,15 +2,12 @@ package compiler
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
-	"os"
+	"strconv"
+	"strings"
 )
 
-// Error
-var tokenError error = errors.New("not supported token")
-
 // DFA的状态
 type StateType int
 
@@ -18,19 +15,30 @@ const (
 	START StateType = iota
 	INCOMMENT
 	INSTRING
+	INESCAPE // 字符串中\之后的字符
 	INNUM
+	INFRAC    // 浮点数的小数部分
+	INEXP     // 浮点数的指数部分：e之后
+	INEXPSIGN // 浮点数的指数部分：符号之后
+	INEXPNUM  // 浮点数的指数部分：数字
 	INID
-	INEQ  // ==
-	ININC // ++
-	INLE  // <=
-	INGE  // >=
-	INNE  // !=
+	INEQ     // ==
+	ININC    // ++
+	INDEC    // --
+	INDEFINE // :=
+	INLE     // <=
+	INGE     // >=
+	INNE     // !=
+	INAND    // &&
+	INANDNOT // &^
+	INOR     // ||
+	INSHL    // <<
+	INSHR    // >>
 	DONE
 )
 
 // Scanner
 type Scanner struct {
-	file     *os.File // 源文件
 	buf      *bufio.Reader
 	linebuf  string // 当前行
 	ch       int    // 当前字符
@@ -38,41 +46,64 @@ type Scanner struct {
 	linepos  int    // 下一个待读取字符在当前行的位置
 	err      error
 	trace    map[int]bool
+	ctx      *Compilation
+	src      *File // 源文件的行表
+	lineoff  int   // 当前行起始位置的偏移量
 }
 
-func NewScanner(file *os.File) *Scanner {
+// NewScanner 创建一个从r读取源代码的扫描器，name为源文件名
+func NewScanner(ctx *Compilation, name string, r io.Reader) *Scanner {
 	s := Scanner{
-		file:     file,
-		buf:      bufio.NewReader(file),
+		buf:      bufio.NewReader(r),
 		linesize: 0,
 		linepos:  0,
+		ctx:      ctx,
+		src:      ctx.Files.AddFile(name),
 	}
 	s.next()
 	return &s
 }
 
-func (s *Scanner) error(err error) {
-	fmt.Println("Scan Error>> Line%d: %s\n, Position%d: %v\n", GLineno, s.linebuf, s.linepos, s.linebuf[s.linepos])
-	panic(err)
+// error 在当前字符处报告错误，扫描继续进行
+func (s *Scanner) error(msg string) {
+	s.ctx.addDiagnostic(&Diagnostic{
+		Severity: SevError,
+		Pos:      s.pos(),
+		Msg:      msg,
+	})
+}
+
+// pos 返回当前字符的位置
+func (s *Scanner) pos() Pos {
+	// linepos指向下一个字符，恰好是当前字符从1开始的列号
+	return Pos{
+		File:   s.src.Name(),
+		Offset: s.lineoff + s.linepos - 1,
+		Line:   s.src.LineCount(),
+		Column: s.linepos,
+	}
 }
 
 // next 获取当前行的下一个非空字符，当前行无字符时读取新行
 func (s *Scanner) next() {
 	if !(s.linepos < s.linesize) {
-		GLineno++
-
-		var err error
-		s.linebuf, err = s.buf.ReadString('\n')
+		line, err := s.buf.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil // 最后一行没有换行符
+		}
 		if err != nil {
 			if err == io.EOF {
-				s.ch = -1
+				s.ch = -1 // 文件结束时位置停在最后一行的末尾
 				return
 			} else {
-				s.error(err)
+				s.error(err.Error())
+				s.ch = -1
 				return
 			}
 		}
 
+		s.lineoff = s.src.addLine(line)
+		s.linebuf = line
 		s.linesize = len(s.linebuf)
 		s.linepos = 0
 		s.ch = int(s.linebuf[s.linepos])
@@ -101,6 +132,9 @@ func (s *Scanner) prev() int {
 	}
 }
 
+// 字符串中\之后可以出现的字符：单字符转义、\x、八进制数字、\u和\U
+const escapes = "abfnrtv\\\"x01234567uU"
+
 func isalpha(c int) bool {
 	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
 }
@@ -115,14 +149,19 @@ func isdigit(c int) bool {
 //	@receiver s
 //	@return token 记号
 //	@return lit 记号的值（如标识符名、数字）
-func (s *Scanner) GetToken() (token Token, lit string) {
+//	@return pos 记号的起始位置
+func (s *Scanner) GetToken() (token Token, lit string, pos Pos) {
 	var state StateType = START
 	var save bool
+	var badstr bool // 字符串中已报告过错误
 	lit = ""
 
 	for state != DONE {
 		c := s.ch
 		save = true
+		if state == START && c != ' ' && c != '\t' && c != '\n' {
+			pos = s.pos()
+		}
 
 		switch state {
 		case START:
@@ -145,7 +184,11 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 						token = ADD
 					}
 				case '-':
-					token = SUB
+					if s.prev() == '-' {
+						state = INDEC
+					} else {
+						token = SUB
+					}
 				case '*':
 					token = MUL
 				case '%':
@@ -166,12 +209,16 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 				case '<':
 					if s.prev() == '=' {
 						state = INLE
+					} else if s.prev() == '<' {
+						state = INSHL
 					} else {
 						token = LT
 					}
 				case '>':
 					if s.prev() == '=' {
 						state = INGE
+					} else if s.prev() == '>' {
+						state = INSHR
 					} else {
 						token = GT
 					}
@@ -187,6 +234,10 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 					token = LPAREN
 				case ')':
 					token = RPAREN
+				case '[':
+					token = LBRACK
+				case ']':
+					token = RBRACK
 				case '{':
 					token = LBRACE
 				case '}':
@@ -194,12 +245,37 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 				case ';':
 					token = SEMI
 				case '.':
-					token = PERIOD
+					if isdigit(s.prev()) {
+						state = INFRAC // .5
+					} else {
+						token = PERIOD
+					}
 				case ',':
 					token = COMMA
+				case ':':
+					if s.prev() == '=' {
+						state = INDEFINE
+					} else {
+						token = COLON
+					}
 				case '&':
-					token = AMPER
+					if s.prev() == '&' {
+						state = INAND
+					} else if s.prev() == '^' {
+						state = INANDNOT
+					} else {
+						token = AMPER
+					}
+				case '|':
+					if s.prev() == '|' {
+						state = INOR
+					} else {
+						token = PIPE
+					}
+				case '^':
+					token = XOR
 				default:
+					s.error(fmt.Sprintf("invalid character %q", rune(c)))
 					token = ERROR
 				}
 			}
@@ -211,20 +287,69 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 			} else if c == '\n' {
 				state = START
 			}
-		case INSTRING:
-			if c == '"' {
+		case INSTRING, INESCAPE:
+			if c == -1 || c == '\n' {
+				s.error("string literal not terminated")
+				badstr = true
+				save = false
 				state = DONE
-				token = STRING
+				token = STRLIT
+			} else if state == INESCAPE {
+				// 转义序列的其余字符在字符串结束后检查
+				if !strings.ContainsRune(escapes, rune(c)) {
+					s.error("unknown escape")
+					badstr = true
+				}
+				state = INSTRING
+			} else if c == '\\' {
+				state = INESCAPE
+			} else if c == '"' {
+				state = DONE
+				token = STRLIT
 			}
 		case INNUM:
-			if !isdigit(c) {
+			if c == '.' {
+				state = INFRAC
+			} else if c == 'e' || c == 'E' {
+				state = INEXP
+			} else if !isdigit(c) {
 				s.unget()
 				save = false
 				state = DONE
 				token = NUM
 			}
+		case INFRAC:
+			if c == 'e' || c == 'E' {
+				state = INEXP
+			} else if !isdigit(c) {
+				s.unget()
+				save = false
+				state = DONE
+				token = FNUM
+			}
+		case INEXP, INEXPSIGN:
+			// e之后是可选的符号和至少一个数字
+			if isdigit(c) {
+				state = INEXPNUM
+			} else if state == INEXP && (c == '+' || c == '-') {
+				state = INEXPSIGN
+			} else {
+				s.error("exponent has no digits")
+				s.unget()
+				save = false
+				state = DONE
+				token = FNUM
+			}
+		case INEXPNUM:
+			if !isdigit(c) {
+				s.unget()
+				save = false
+				state = DONE
+				token = FNUM
+			}
 		case INID:
-			if !isalpha(c) {
+			// 标识符的首字符之后可以是数字，如int8
+			if !isalpha(c) && !isdigit(c) {
 				s.unget()
 				save = false
 				state = DONE
@@ -245,15 +370,36 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 		case ININC:
 			state = DONE
 			token = INC
+		case INDEC:
+			state = DONE
+			token = DEC
+		case INDEFINE:
+			state = DONE
+			token = DEFINE
+		case INAND:
+			state = DONE
+			token = AND
+		case INANDNOT:
+			state = DONE
+			token = ANDNOT
+		case INOR:
+			state = DONE
+			token = OR
+		case INSHL:
+			state = DONE
+			token = SHL
+		case INSHR:
+			state = DONE
+			token = SHR
 		case DONE:
 		default:
 			state = DONE
 			token = ERROR
-			s.error(tokenError)
+			s.error("invalid scanner state")
 		}
 
 		if save {
-			lit += string(c)
+			lit += string([]byte{byte(c)}) // 按字节保存，字符串中的UTF-8字符保持不变
 		}
 		if state == DONE {
 			if token == ID {
@@ -262,20 +408,24 @@ func (s *Scanner) GetToken() (token Token, lit string) {
 					token = _token
 				}
 			}
+			if token == STRLIT && !badstr {
+				if _, err := strconv.Unquote(lit); err != nil {
+					s.error("invalid escape sequence in string literal")
+				}
+			}
 		}
 		s.next()
 	}
-	if GTraceScan {
+	if s.ctx.Opts.TraceTokens {
+		w := s.ctx.Opts.traceWriter()
 		if s.trace == nil {
 			s.trace = make(map[int]bool)
 		}
-		if s.trace[GLineno] {
-			fmt.Printf("\tToken: %-8s, Lit: %s\n", tokens[token], lit)
-		} else {
-			fmt.Printf("Line%d: %s", GLineno, s.linebuf)
-			fmt.Printf("\tToken: %-8s, Lit: %s\n", tokens[token], lit)
-			s.trace[GLineno] = true
+		if line := s.src.LineCount(); !s.trace[line] {
+			fmt.Fprintf(w, "Line%d: %s", line, s.linebuf)
+			s.trace[line] = true
 		}
+		fmt.Fprintf(w, "\tToken: %-8s, Lit: %s\n", tokens[token], lit)
 	}
 	return
 }
@@ -14,14 +14,30 @@ const (
 	INT
 	FLOAT
 	CHAR
+	BOOL
 	STRING
+	INT8
+	INT16
+	INT32
+	INT64
+	UINT
+	UINT8
+	UINT16
+	UINT32
+	UINT64
+	BYTE
+	FLOAT32
+	FLOAT64
 
 	// 以下为多字符记号
 	ID
 	NUM
+	FNUM   // 浮点数
+	STRLIT // 字符串
 
 	// 以下为特殊符号
 	ASSIGN // =
+	DEFINE // :=
 	EQ     // ==
 	LT     // <
 	GT     // >
@@ -39,7 +55,13 @@ const (
 	QUO // /
 	REM // %
 	INC // ++
-	AMPER
+	DEC // --
+	AMPER  // &
+	PIPE   // |
+	XOR    // ^
+	SHL    // <<
+	SHR    // >>
+	ANDNOT // &^
 
 	LPAREN // (
 	RPAREN // )
@@ -58,6 +80,7 @@ const (
 	FOR
 	BREAK
 	CONTINUE
+	GOTO
 
 	PACKAGE
 	IMPORT
@@ -66,6 +89,8 @@ const (
 	FUNC
 	PRINT
 	RETURN
+	TRUE
+	FALSE
 )
 
 var tokens = [...]string{
@@ -77,14 +102,30 @@ var tokens = [...]string{
 	"INT",
 	"FLOAT",
 	"CHAR",
+	"BOOL",
 	"STRING",
+	"INT8",
+	"INT16",
+	"INT32",
+	"INT64",
+	"UINT",
+	"UINT8",
+	"UINT16",
+	"UINT32",
+	"UINT64",
+	"BYTE",
+	"FLOAT32",
+	"FLOAT64",
 
 	// 以下为多字符记号
 	"ID",
 	"NUM",
+	"FNUM",
+	"STRLIT",
 
 	// 以下为特殊符号
 	"ASSIGN", // =
+	"DEFINE", // :=
 	"EQ",     // ==
 	"LT",     // <
 	"GT",     // >
@@ -102,7 +143,13 @@ var tokens = [...]string{
 	"QUO", // /
 	"REM", // %
 	"INC", // ++
+	"DEC", // --
 	"AMPER", // &
+	"PIPE",   // |
+	"XOR",    // ^
+	"SHL",    // <<
+	"SHR",    // >>
+	"ANDNOT", // &^
 
 	"LPAREN", // (
 	"RPAREN", // )
@@ -121,6 +168,7 @@ var tokens = [...]string{
 	"FOR",
 	"BREAK",
 	"CONTINUE",
+	"GOTO",
 
 	"PACKAGE",
 	"IMPORT",
@@ -129,6 +177,8 @@ var tokens = [...]string{
 	"FUNC",
 	"PRINT",
 	"RETURN",
+	"TRUE",
+	"FALSE",
 }
 
 var lit2token = map[string]Token{
@@ -137,6 +187,7 @@ var lit2token = map[string]Token{
 	"for":      FOR,
 	"break":    BREAK,
 	"continue": CONTINUE,
+	"goto":     GOTO,
 	"package":  PACKAGE,
 	"import":   IMPORT,
 	"var":      VAR,
@@ -145,6 +196,81 @@ var lit2token = map[string]Token{
 	"int":      INT,
 	"float":    FLOAT,
 	"char":     CHAR,
+	"bool":     BOOL,
+	"string":   STRING,
+	"int8":     INT8,
+	"int16":    INT16,
+	"int32":    INT32,
+	"int64":    INT64,
+	"uint":     UINT,
+	"uint8":    UINT8,
+	"uint16":   UINT16,
+	"uint32":   UINT32,
+	"uint64":   UINT64,
+	"byte":     BYTE,
+	"float32":  FLOAT32,
+	"float64":  FLOAT64,
 	"print":    PRINT,
 	"return":   RETURN,
+	"true":     TRUE,
+	"false":    FALSE,
+}
+
+// 特殊符号对应的源码文本，用于错误信息
+var token2lit = map[Token]string{
+	ASSIGN: "=",
+	DEFINE: ":=",
+	EQ:     "==",
+	LT:     "<",
+	GT:     ">",
+	GE:     ">=",
+	LE:     "<=",
+	NE:     "!=",
+	AND:    "&&",
+	OR:     "||",
+	NOT:    "!",
+	ADD:    "+",
+	SUB:    "-",
+	MUL:    "*",
+	QUO:    "/",
+	REM:    "%",
+	INC:    "++",
+	DEC:    "--",
+	AMPER:  "&",
+	PIPE:   "|",
+	XOR:    "^",
+	SHL:    "<<",
+	SHR:    ">>",
+	ANDNOT: "&^",
+	LPAREN: "(",
+	RPAREN: ")",
+	LBRACK: "[",
+	RBRACK: "]",
+	LBRACE: "{",
+	RBRACE: "}",
+	SEMI:   ";",
+	COMMA:  ",",
+	PERIOD: ".",
+	COLON:  ":",
+}
+
+// String 返回记号在源码中的写法，关键字和符号之外的记号返回其名字
+func (t Token) String() string {
+	if lit, ok := token2lit[t]; ok {
+		return lit
+	}
+	for lit, tok := range lit2token {
+		if tok == t && tok != IDENT {
+			return lit
+		}
+	}
+	switch t {
+	case ENDFILE:
+		return "EOF"
+	case ID:
+		return "name"
+	case NUM, FNUM, STRLIT:
+		return "literal"
+	}
+	return tokens[t]
 }
@@ -1,26 +1,143 @@
 package compiler
 
-var Gsym *Symtable
-const max_glob = 1024
+import "fmt"
 
 type Type int
 const (
-    VAR_CHAR Type = iota
+    VAR_CHAR Type = iota  // 即uint8和byte
     VAR_INT
-    VAR_FLOAT
-    VAR_STRING
-    VAR_POINTER_CHAR
-    VAR_POINTER_INT
+    VAR_FLOAT  // 即float64
+    VAR_STRING  // 指向字符串头{数据地址, 长度}的指针，字符串头不可修改
     VAR_ARRAY
     VAR_STRCUT
     VAR_INTERFACE
     VAR_FUNC
+    VAR_BOOL
+    VAR_INT8
+    VAR_INT16
+    VAR_INT32
+    VAR_INT64
+    VAR_UINT
+    VAR_UINT16
+    VAR_UINT32
+    VAR_UINT64
+    VAR_FLOAT32
+
+    // 以下类型只出现在语义分析中
+    UNTYPED_INT    // 整数常量
+    UNTYPED_FLOAT  // 浮点数常量
+    UNTYPED_STRING // 字符串常量
+    UNTYPED_BOOL   // 比较运算的结果
+    VAR_INVALID    // 有错误的表达式，不再报告与它有关的错误
+)
+
+// 指针类型由指针标志和指向的类型组成，如VAR_POINTER|VAR_INT为*int
+const VAR_POINTER Type = 0x100
+
+const (
+    VAR_POINTER_CHAR = VAR_POINTER | VAR_CHAR
+    VAR_POINTER_INT  = VAR_POINTER | VAR_INT
 )
 
+var typeNames = map[Type]string{
+    VAR_CHAR:       "uint8",
+    VAR_INT:        "int",
+    VAR_BOOL:       "bool",
+    VAR_INT8:       "int8",
+    VAR_INT16:      "int16",
+    VAR_INT32:      "int32",
+    VAR_INT64:      "int64",
+    VAR_UINT:       "uint",
+    VAR_UINT16:     "uint16",
+    VAR_UINT32:     "uint32",
+    VAR_UINT64:     "uint64",
+    VAR_FLOAT:      "float64",
+    VAR_FLOAT32:    "float32",
+    VAR_STRING:     "string",
+    UNTYPED_INT:    "untyped int",
+    UNTYPED_FLOAT:  "untyped float",
+    UNTYPED_STRING: "untyped string",
+    UNTYPED_BOOL:   "untyped bool",
+    VAR_INVALID:    "invalid type",
+}
+
+func (t Type) String() string {
+    if isPointer(t) {
+        return "*" + elemType(t).String()
+    }
+    if name, ok := typeNames[t]; ok {
+        return name
+    }
+    return fmt.Sprintf("Type(%d)", int(t))
+}
+
+func isPointer(t Type) bool {
+    return t&VAR_POINTER != 0
+}
+
+// 指针类型t指向的类型
+func elemType(t Type) Type {
+    return t &^ VAR_POINTER
+}
+
+// 指向类型t的指针类型
+func pointerTo(t Type) Type {
+    return t | VAR_POINTER
+}
+
+// 类型t的值占用的字节数，不能保存在变量中的类型为0
+func typeSize(t Type) int {
+    switch {
+    case isPointer(t):
+        return 8
+    case t == VAR_CHAR || t == VAR_INT8:
+        return 1
+    case t == VAR_INT16 || t == VAR_UINT16:
+        return 2
+    case t == VAR_INT32 || t == VAR_UINT32 || t == VAR_FLOAT32:
+        return 4
+    case t == VAR_INT || t == VAR_INT64 || t == VAR_UINT || t == VAR_UINT64 || t == VAR_BOOL || t == VAR_FLOAT || t == VAR_STRING:
+        return 8
+    }
+    return 0
+}
+
+// 有符号整数类型
+func isSigned(t Type) bool {
+    switch t {
+    case VAR_INT, VAR_INT8, VAR_INT16, VAR_INT32, VAR_INT64:
+        return true
+    }
+    return false
+}
+
+// 浮点数类型，寄存器中保存浮点数的二进制表示，运算时移入%xmm寄存器
+func isFloat(t Type) bool {
+    return t == VAR_FLOAT || t == VAR_FLOAT32 || t == UNTYPED_FLOAT
+}
+
+// 字符串类型，包括字符串常量
+func isString(t Type) bool {
+    return t == VAR_STRING || t == UNTYPED_STRING
+}
+
+// 无符号整数类型，除法、比较和右移按无符号数进行
+func isUnsigned(t Type) bool {
+    switch t {
+    case VAR_CHAR, VAR_UINT, VAR_UINT16, VAR_UINT32, VAR_UINT64:
+        return true
+    }
+    return false
+}
+
+// 符号表。全局符号和局部变量都保存在symbles中，插槽位置即下标，
+// 语法树通过插槽位置引用符号，所以插槽在整个编译过程中不会释放或移动
 type Symtable struct {
     symbles []Symble
-    globs   int  // 下一个可用的插槽
-    local_globs int  // 下一个可用的局部变量插槽，从数组末尾开始
+    globs   []int  // 全局符号的插槽位置
+    locals  []int  // 局部变量的插槽位置，按添加的顺序
+    scopes  []int  // 当前打开的作用域，内层作用域在后
+    nscope  int    // 已分配的作用域编号
 }
 
 type Symble struct {
@@ -29,25 +146,23 @@ type Symble struct {
 
     IsLocal bool     // 是否是局部变量
     BelongFunc int   // 局部变量所属的函数
+    Scope int        // 局部变量所属的作用域
+    Pos Pos          // 声明的位置
     Offset int       // 局部变量的偏移量
 
     EndLabel int     // 函数的末尾标签，用于return语句
-    ReturnType Type  // 函数的返回类型
+    Results []Type   // 函数的返回值类型
     FuncOffset int   // rsp栈顶的对齐偏移量
+    Params []Type    // 函数形参的类型
 }
 
-func init() {
-    Gsym = &Symtable{
-        symbles: make([]Symble, max_glob),
-        globs:   0,
-        local_globs: max_glob-1,
-    }
+func NewSymtable() *Symtable {
+    return &Symtable{}
 }
 
 // 查找全局符号name的插槽位置
 func (s *Symtable) Findglob(name string) int {
-    var i int
-    for i = 0; i < s.globs; i++ {
+    for _, i := range s.globs {
         if s.symbles[i].Name == name {
             return i
         }
@@ -55,13 +170,17 @@ func (s *Symtable) Findglob(name string) int {
     return -1
 }
 
-// 返回下一个可用的全局插槽位置
+// 返回一个新的插槽位置
+func (s *Symtable) newslot() int {
+    s.symbles = append(s.symbles, Symble{})
+    return len(s.symbles) - 1
+}
+
+// 返回一个新的全局插槽位置
 func (s *Symtable) Newglob() int {
-    if s.globs >= s.local_globs {
-        panic("Error: no available globs")
-    }
-    s.globs++
-    return s.globs-1
+    i := s.newslot()
+    s.globs = append(s.globs, i)
+    return i
 }
 
 // 新增一个全局符号到符号表
@@ -83,8 +202,17 @@ func (s *Symtable) SetEndLabel(glob int, value int) {
     s.symbles[glob].EndLabel = value
 }
 
-func (s *Symtable) SetReturnType(glob int, value Type) {
-    s.symbles[glob].ReturnType = value
+func (s *Symtable) AddResult(glob int, value Type) {
+    s.symbles[glob].Results = append(s.symbles[glob].Results, value)
+}
+
+func (s *Symtable) AddParam(glob int, value Type) {
+    s.symbles[glob].Params = append(s.symbles[glob].Params, value)
+}
+
+// 函数glob的形参类型
+func (s *Symtable) ParamTypes(glob int) []Type {
+    return append([]Type(nil), s.symbles[glob].Params...)
 }
 
 func (s *Symtable) SetBelongFunc(glob int, value int) {
@@ -110,37 +238,72 @@ func (s *Symtable) GetFuncOffset(glob int) int {
 }
 
 ////////////////////////////////// 局部变量 ////////////////////////////
-// 查找符号name的插槽位置
+// 打开一个新的作用域（函数体、语句块、if和for语句）
+func (s *Symtable) Openscope() {
+    s.nscope++
+    s.scopes = append(s.scopes, s.nscope)
+}
+
+// 关闭最内层的作用域，其中的局部变量不再可见
+func (s *Symtable) Closescope() {
+    s.scopes = s.scopes[:len(s.scopes)-1]
+}
+
+// 当前打开的作用域，外层在前
+func (s *Symtable) Scopes() []int {
+    return append([]int(nil), s.scopes...)
+}
+
+func (s *Symtable) isopen(scope int) bool {
+    for _, id := range s.scopes {
+        if id == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// 在打开的作用域中查找符号name的插槽位置，内层作用域的符号遮蔽外层的同名符号
 func (s *Symtable) Findlocal(name string) int {
-    var i int
-    for i = max_glob-1; i >= s.local_globs; i-- {
-        if s.symbles[i].Name == name {
+    // 后添加的符号位于更内层的作用域，所以从最近添加的符号开始查找
+    for k := len(s.locals) - 1; k >= 0; k-- {
+        if i := s.locals[k]; s.symbles[i].Name == name && s.isopen(s.symbles[i].Scope) {
             return i
         }
     }
     return -1
 }
 
-// 返回下一个可用的插槽位置
-func (s *Symtable) Newlocal() int {
-    if s.globs >= s.local_globs {
-        panic("Error: no available globs")
+// 只在最内层的作用域中查找符号name的插槽位置
+func (s *Symtable) Findscope(name string) int {
+    if len(s.scopes) == 0 {
+        return -1
+    }
+    scope := s.scopes[len(s.scopes)-1]
+    for k := len(s.locals) - 1; k >= 0; k-- {
+        if i := s.locals[k]; s.symbles[i].Name == name && s.symbles[i].Scope == scope {
+            return i
+        }
     }
-    s.local_globs--
-    return s.local_globs+1
+    return -1
 }
 
-// 新增一个符号到符号表
-func (s *Symtable) Addlocal(name string, vartype Type) int {
-    var i int
-    if i = s.Findlocal(name); i != -1 {
-        return i
-    }
+// 返回一个新的局部变量插槽位置
+func (s *Symtable) Newlocal() int {
+    i := s.newslot()
+    s.locals = append(s.locals, i)
+    return i
+}
 
-    i = s.Newlocal()
+// 新增一个符号到最内层的作用域
+func (s *Symtable) Addlocal(name string, vartype Type) int {
+    i := s.Newlocal()
     s.symbles[i].Name = name
     s.symbles[i].Vartype = vartype
     s.symbles[i].IsLocal = true
+    if len(s.scopes) > 0 {
+        s.symbles[i].Scope = s.scopes[len(s.scopes)-1]
+    }
     return i
 }
 
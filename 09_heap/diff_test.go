@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var differential = flag.Bool("differential", false, "also run testdata programs with the go command and compare the output")
+
+// goPrelude 与改写后的源文件组成合法的Go程序：print语句改写为调用mygoPrint
+const goPrelude = `package main
+
+import "fmt"
+
+type char = uint8
+
+// mygo中比较运算的结果为整数0或1，其余的值与fmt.Println的输出相同
+func mygoPrint(v any) {
+	if b, ok := v.(bool); ok {
+		v = 0
+		if b {
+			v = 1
+		}
+	}
+	fmt.Println(v)
+}
+
+`
+
+var printStmt = regexp.MustCompile(`\bprint\s+([^;{}\n]+)`)
+
+// toGo 将mygo源文件改写为等价的Go源文件，与goPrelude位于同一个包中
+func toGo(src []byte) []byte {
+	body := printStmt.ReplaceAll(src, []byte("mygoPrint($1)"))
+	return append([]byte("package main\n\n"), body...)
+}
+
+// TestDifferential 用go命令运行每个testdata程序，并与mygo编译的结果比较，
+// 以发现代码生成中的错误。Go编译器不接受的程序（如未使用的变量）会被跳过。
+func TestDifferential(t *testing.T) {
+	if !*differential {
+		t.Skip("run with -differential to compare against the go command")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go command not available: %v", err)
+	}
+
+	for prog, srcs := range testPrograms(t) {
+		prog, srcs := prog, srcs
+		t.Run(filepath.Base(prog), func(t *testing.T) {
+			t.Parallel()
+			tmpdir := t.TempDir()
+
+			exe := filepath.Join(tmpdir, "a.out")
+			if err := buildExecutable(srcs, exe, tmpdir); err != nil {
+				t.Skipf("mygo rejects program: %v", err)
+			}
+			want, err := exec.Command(exe).Output()
+			if err != nil {
+				t.Skipf("program does not exit normally: %v", err)
+			}
+
+			// 包中的每个源文件改写为一个Go源文件，与goPrelude一起编译
+			prelude := filepath.Join(tmpdir, "mygo_prelude.go")
+			if err := os.WriteFile(prelude, []byte(goPrelude), 0644); err != nil {
+				t.Fatal(err)
+			}
+			gofiles := []string{prelude}
+			for _, src := range srcs {
+				data, err := os.ReadFile(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gofile := filepath.Join(tmpdir, strings.TrimSuffix(filepath.Base(src), ".mygo")+".go")
+				if err := os.WriteFile(gofile, toGo(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+				gofiles = append(gofiles, gofile)
+			}
+			gobin := filepath.Join(tmpdir, "gobin")
+			args := append([]string{"build", "-o", gobin}, gofiles...)
+			if out, err := exec.Command(gocmd, args...).CombinedOutput(); err != nil {
+				t.Skipf("go rejects program:\n%s", out)
+			}
+			got, err := exec.Command(gobin).Output()
+			if err != nil {
+				t.Fatalf("go program failed: %v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs for %s\n--- go ---\n%s--- mygo ---\n%s", prog, got, want)
+			}
+		})
+	}
+}
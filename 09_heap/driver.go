@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	compiler "mygo/compiler"
+)
+
+// 编译选项，由命令行参数设置
+var opts = compiler.Options{Trace: os.Stderr}
+
+// compileFlags 在fs中注册控制编译过程的参数
+func compileFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&opts.TraceTokens, "trace-tokens", false, "print each scanned token")
+	fs.BoolVar(&opts.DumpAST, "dump-ast", false, "print the syntax tree")
+	fs.IntVar(&opts.MaxErrors, "max-errors", 10, "stop after reporting `n` errors")
+}
+
+// compile 将组成一个包的源文件srcs编译为汇编文件dst
+func compile(srcs []string, dst string) (err error) {
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, src := range srcs {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+	src := srcs[0]
+
+	outfile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := outfile.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	// 编译器内部错误不应使命令行工具崩溃
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: internal compiler error: %v", src, r)
+		}
+	}()
+
+	ctx := compiler.NewCompilation(opts)
+	defer func() {
+		if _, ok := err.(compiler.DiagnosticList); ok {
+			err = &compileError{files: ctx.Files, src: src, diags: ctx.Diagnostics()}
+		}
+	}()
+
+	p := compiler.NewParser(ctx, src, files[0])
+	for i, file := range files[1:] {
+		p.AddFile(srcs[i+1], file)
+	}
+	tree, err := p.Parse()
+	if err != nil {
+		return err
+	}
+	if err := compiler.NewChecker(ctx, tree).Check(); err != nil {
+		return err
+	}
+	return compiler.NewCgen(ctx, tree, outfile).GenAST()
+}
+
+// compileError 编译一个源文件时产生的诊断信息
+type compileError struct {
+	files *compiler.FileSet
+	src   string
+	diags compiler.DiagnosticList
+}
+
+func (e *compileError) Error() string {
+	return e.diags.Error()
+}
+
+// report 打印错误。诊断信息按 file:line:col: message 的格式输出，
+// 并附上出错的源代码行，用^标出出错的列
+func report(err error) {
+	var cerr *compileError
+	if !errors.As(err, &cerr) {
+		fmt.Fprintf(os.Stderr, "mygo: %v\n", err)
+		return
+	}
+	for _, d := range cerr.diags {
+		if d.Pos.File == "" {
+			d.Pos.File = cerr.src
+		}
+		fmt.Fprintln(os.Stderr, d)
+		if line, ok := cerr.files.Line(d.Pos); ok && d.Pos.Column > 0 {
+			fmt.Fprintf(os.Stderr, "%s\n%s^\n", line, caretIndent(line, d.Pos.Column))
+		}
+		for _, note := range d.Notes {
+			fmt.Fprintf(os.Stderr, "\tnote: %s\n", note)
+		}
+	}
+}
+
+// caretIndent 返回使^对齐到第col列所需的缩进，保留行中的制表符
+func caretIndent(line string, col int) string {
+	var b strings.Builder
+	for i := 0; i < col-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// compileObject 将源文件srcs编译并汇编为目标文件obj，中间文件放在tmpdir
+func compileObject(srcs []string, obj, tmpdir string) error {
+	asm := filepath.Join(tmpdir, filepath.Base(obj)+".s")
+	if err := compile(srcs, asm); err != nil {
+		return err
+	}
+	return assemble(asm, obj)
+}
+
+// buildExecutable 将所有源文件作为一个包编译、汇编并链接为可执行文件exe
+func buildExecutable(srcs []string, exe, tmpdir string) error {
+	obj := filepath.Join(tmpdir, "main.o")
+	if err := compileObject(srcs, obj, tmpdir); err != nil {
+		return err
+	}
+	return link([]string{obj}, exe)
+}
+
+// assemble 调用系统汇编器
+func assemble(asm, obj string) error {
+	return runTool(toolName("AS", "as"), "-o", obj, asm)
+}
+
+// link 调用系统C编译器链接目标文件，以便使用libc中的printf
+func link(objs []string, out string) error {
+	args := append([]string{"-o", out}, objs...)
+	return runTool(toolName("CC", "cc"), args...)
+}
+
+// toolName 返回工具名，可通过环境变量env覆盖
+func toolName(env, def string) string {
+	if name := os.Getenv(env); name != "" {
+		return name
+	}
+	return def
+}
+
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the testdata/*.out golden files")
+
+// TestGolden 编译testdata中的每个程序，用系统工具链汇编、链接并运行，
+// 将输出与同名的.out文件比较。程序非正常退出时，.out的最后一行为
+// [exit status N]或[signal: ...]；编译失败时.out为编译器输出的诊断信息。
+func TestGolden(t *testing.T) {
+	for _, tool := range []string{toolName("AS", "as"), toolName("CC", "cc")} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("toolchain not available: %v", err)
+		}
+	}
+
+	for prog, srcs := range testPrograms(t) {
+		prog, srcs := prog, srcs
+		t.Run(filepath.Base(prog), func(t *testing.T) {
+			t.Parallel()
+			got := runProgram(t, srcs)
+			golden := prog + ".out"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output mismatch for %s\n--- got ---\n%s--- want ---\n%s", prog, got, want)
+			}
+		})
+	}
+}
+
+// testPrograms 返回testdata中的全部程序及其源文件，程序名不含扩展名。
+// 每个.mygo文件是一个程序；子目录中的全部.mygo文件组成一个程序，.out文件与子目录同名
+func testPrograms(t *testing.T) map[string][]string {
+	progs := make(map[string][]string)
+	srcs, err := filepath.Glob(filepath.Join("testdata", "*.mygo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range srcs {
+		progs[strings.TrimSuffix(src, ".mygo")] = []string{src}
+	}
+	srcs, err = filepath.Glob(filepath.Join("testdata", "*", "*.mygo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range srcs {
+		dir := filepath.Dir(src)
+		progs[dir] = append(progs[dir], src)
+	}
+	return progs
+}
+
+// runProgram 将srcs作为一个包编译并运行，返回需要与.out文件比较的内容
+func runProgram(t *testing.T, srcs []string) []byte {
+	tmpdir := t.TempDir()
+	exe := filepath.Join(tmpdir, "a.out")
+	if err := buildExecutable(srcs, exe, tmpdir); err != nil {
+		var cerr *compileError
+		if !errors.As(err, &cerr) {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		for _, d := range cerr.diags {
+			fmt.Fprintln(&b, d)
+		}
+		return b.Bytes()
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintf(&stdout, "[%v]\n", exitErr)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.Bytes()
+}
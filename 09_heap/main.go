@@ -1,30 +1,77 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+)
 
-	compiler "mygo/compiler"
+var (
+	flagS   = flag.Bool("S", false, "compile only; write assembly to file.s")
+	flagC   = flag.Bool("c", false, "compile and assemble; write object to file.o")
+	flagOut = flag.String("o", "", "write output to `file`")
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: mygo [flags] [-S | -c] [-o file] file.mygo...\n")
+	fmt.Fprintf(os.Stderr, "       mygo run [flags] file.mygo... [arguments...]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
 func main() {
-	file, err := os.OpenFile("./sample/sample.mygo", os.O_RDWR, 0666)
-	if err != nil {
-		panic(err)
+	flag.Usage = usage
+	compileFlags(flag.CommandLine)
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runMain(os.Args[2:]))
 	}
+	flag.Parse()
 
-	parser := compiler.NewParser(file)
-	tree := parser.Parse()
+	if err := build(flag.Args()); err != nil {
+		report(err)
+		os.Exit(1)
+	}
+}
 
-	outfile, err := os.OpenFile("./sample/sample.s", os.O_CREATE|os.O_TRUNC, 0666)
-	defer outfile.Close()
-	if err != nil {
-		panic(err)
+func build(srcs []string) error {
+	if len(srcs) == 0 {
+		usage()
+	}
+	if *flagS && *flagC {
+		return fmt.Errorf("-S and -c are mutually exclusive")
 	}
 
-	gen := compiler.NewCgen(tree, outfile)
-	gen.GenAST()
+	// 全部源文件组成一个包，-S和-c的输出文件以第一个源文件命名
+	// -S: 只生成汇编
+	if *flagS {
+		return compile(srcs, outName(srcs[0], ".s"))
+	}
 
+	tmpdir, err := os.MkdirTemp("", "mygo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
 
+	// -c: 生成目标文件
+	if *flagC {
+		return compileObject(srcs, outName(srcs[0], ".o"), tmpdir)
+	}
 
+	// 默认：编译、汇编并链接为可执行文件
+	out := *flagOut
+	if out == "" {
+		out = "a.out"
+	}
+	return buildExecutable(srcs, out, tmpdir)
+}
 
+// outName 返回输入文件src对应的输出文件名
+func outName(src, ext string) string {
+	if *flagOut != "" {
+		return *flagOut
+	}
+	return strings.TrimSuffix(filepath.Base(src), filepath.Ext(src)) + ext
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runMain 实现 mygo run 子命令，返回进程的退出码
+func runMain(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	compileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mygo run [flags] file.mygo... [arguments...]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	_ = fs.Parse(args)
+	args = fs.Args()
+
+	// 以.mygo结尾的参数为源文件，其余参数传给程序
+	n := 0
+	for n < len(args) && strings.HasSuffix(args[n], ".mygo") {
+		n++
+	}
+	if n == 0 {
+		fs.Usage()
+	}
+
+	code, err := run(args[:n], args[n:])
+	if err != nil {
+		report(err)
+		return 1
+	}
+	return code
+}
+
+// run 在临时目录中编译并链接srcs，然后执行生成的程序。
+// 程序的标准输入输出直接转发，返回程序的退出码。
+func run(srcs, args []string) (int, error) {
+	tmpdir, err := os.MkdirTemp("", "mygo-run")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	exe := filepath.Join(tmpdir, "a.out")
+	if err := buildExecutable(srcs, exe, tmpdir); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code, nil
+		}
+		// 被信号终止
+		return 0, exitErr
+	}
+	return 0, err
+}